@@ -13,6 +13,7 @@ import (
 var ErrOversizedLine = errors.New("line exceeds maximum length")
 var ErrInvalidItem = errors.New("invalid item detected")
 var ErrNoBadgeFound = errors.New("no badge was found for group")
+var ErrInvalidSectionCount = errors.New("section count must be at least 1")
 
 const MaxByesPerLine = 3 * 1024 // 3kB max line length
 const SectionsPerLine = 2
@@ -127,6 +128,10 @@ func findBadgePriorityForGroup(groupLines [3]string) (int, error) {
 // and returns a slice of integers representing the priorities of
 // any runes that appear in more than one section
 func getPrioritiesRepeatedBetweenSections(line string, sections int) (priorities []int, err error) {
+	if sections < 1 {
+		return nil, ErrInvalidSectionCount
+	}
+
 	// priorsToSecs maps priorities to section indices
 	priorsToSecs := make(map[int]int)
 
@@ -1,6 +1,10 @@
 package main
 
-import "testing"
+import (
+	"math"
+	"strings"
+	"testing"
+)
 
 func TestPassingRuneToPriority(t *testing.T) {
 	const sections = 2
@@ -131,3 +135,73 @@ func TestFailingFindBadgePriorityForGroup(t *testing.T) {
 		}
 	}
 }
+
+// priorityToRune is the inverse of runeToPriority
+func priorityToRune(p int) rune {
+	if p > 26 {
+		return rune(p - 27 + 'A')
+	}
+	return rune(p - 1 + 'a')
+}
+
+func FuzzGetPrioritiesRepeatedBetweenSections(f *testing.F) {
+	// Edge cases live in testdata/fuzz/FuzzGetPrioritiesRepeatedBetweenSections
+	seeds := []string{
+		"vJrwpWtwJgWrhcsFMMfFFhFp",
+		"jqHRNqRjqzjGDLGLrsFMfFZSrLrFZsSL",
+		"PmmdzqPrVvPwwTWBwg",
+	}
+	for _, seed := range seeds {
+		f.Add(seed, 2)
+	}
+	f.Fuzz(func(t *testing.T, line string, sections int) {
+		priorities, err := getPrioritiesRepeatedBetweenSections(line, sections)
+		if err != nil {
+			return
+		}
+		runes := []rune(line)
+		secLen := int(math.Ceil(float64(len(runes)) / float64(sections)))
+		seen := make(map[int]bool)
+		for _, p := range priorities {
+			if p < 1 || p > 52 {
+				t.Fatalf("priority %v out of range for %q", p, line)
+			}
+			if seen[p] {
+				t.Fatalf("priority %v reported twice for %q", p, line)
+			}
+			seen[p] = true
+
+			// The item must really appear in more than one section
+			secs := make(map[int]bool)
+			for i, r := range runes {
+				if r == priorityToRune(p) {
+					secs[i/secLen] = true
+				}
+			}
+			if len(secs) < 2 {
+				t.Fatalf("priority %v is not repeated between sections of %q", p, line)
+			}
+		}
+	})
+}
+
+func FuzzFindBadgePriorityForGroup(f *testing.F) {
+	// Edge cases live in testdata/fuzz/FuzzFindBadgePriorityForGroup
+	f.Add("vJrwpWtwJgWrhcsFMMfFFhFp", "jqHRNqRjqzjGDLGLrsFMfFZSrLrFZsSL", "PmmdzqPrVvPwwTWBwg")
+	f.Add("wMqvLMZHhHMvwLHjbvcjnnSBnvTQFn", "ttgJtRGJQctTZtZT", "CrZsJsPPZsGzwwsLwLmpwMDw")
+	f.Fuzz(func(t *testing.T, a, b, c string) {
+		p, err := findBadgePriorityForGroup([3]string{a, b, c})
+		if err != nil {
+			return
+		}
+		if p < 1 || p > 52 {
+			t.Fatalf("badge priority %v out of range for %q, %q, %q", p, a, b, c)
+		}
+		badge := string(priorityToRune(p))
+		for _, line := range []string{a, b, c} {
+			if !strings.Contains(line, badge) {
+				t.Fatalf("badge %v is missing from %q", badge, line)
+			}
+		}
+	})
+}
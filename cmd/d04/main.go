@@ -14,16 +14,66 @@ import (
 type OverlapType int
 
 const (
-	None OverlapType = iota
+	Disjoint OverlapType = iota
+	Adjacent
 	Partial
-	Containing
+	AContainsB
+	BContainsA
+	Equal
 )
 
+// overlapTypes lists every OverlapType in reporting order
+var overlapTypes = []OverlapType{Disjoint, Adjacent, Partial, AContainsB, BContainsA, Equal}
+
+func (o OverlapType) String() string {
+	switch o {
+	case Disjoint:
+		return "Disjoint"
+	case Adjacent:
+		return "Adjacent"
+	case Partial:
+		return "Partial"
+	case AContainsB:
+		return "AContainsB"
+	case BContainsA:
+		return "BContainsA"
+	case Equal:
+		return "Equal"
+	}
+	return fmt.Sprintf("OverlapType(%d)", int(o))
+}
+
+// IsContaining reports whether one assignment fully contains the other
+func (o OverlapType) IsContaining() bool {
+	return o == AContainsB || o == BContainsA || o == Equal
+}
+
+// IsOverlapping reports whether the assignments share at least one section
+func (o OverlapType) IsOverlapping() bool {
+	return o == Partial || o.IsContaining()
+}
+
+// Mirror returns the OverlapType seen when the two assignments are swapped
+func (o OverlapType) Mirror() OverlapType {
+	switch o {
+	case AContainsB:
+		return BContainsA
+	case BContainsA:
+		return AContainsB
+	}
+	return o
+}
+
 type SectionAssignment struct {
 	Start uint64
 	End   uint64
 }
 
+// String formats the assignment the same way it appears in the input
+func (s SectionAssignment) String() string {
+	return fmt.Sprintf("%d-%d", s.Start, s.End)
+}
+
 var lineRegex = regexp.MustCompile(`^([0-9]+)-([0-9]+),([0-9]+)-([0-9]+)$`)
 var ErrOversizedLine = errors.New("line exceeds maximum length")
 var ErrImproperlyFormattedLine = errors.New("improperly formatted line")
@@ -59,6 +109,9 @@ func main() {
 	// Total count of overlapping assignments
 	var total int
 
+	// Count of assignment pairs in each overlap category
+	counts := make(map[OverlapType]int)
+
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
 		line := scanner.Text()
@@ -75,15 +128,16 @@ func main() {
 		}
 
 		overlapType := determineOverlap(assigns[0], assigns[1])
+		counts[overlapType]++
 
 		if !partB {
 			// Part A
-			if overlapType == Containing {
+			if overlapType.IsContaining() {
 				total++
 			}
 		} else {
 			// Part B
-			if overlapType == Containing || overlapType == Partial {
+			if overlapType.IsOverlapping() {
 				total++
 			}
 		}
@@ -91,6 +145,10 @@ func main() {
 	}
 	fmt.Println("-----------------")
 	fmt.Printf("Total is: %v\n", total)
+	fmt.Println("-----------------")
+	for _, o := range overlapTypes {
+		fmt.Printf("%-12v %v\n", o.String()+":", counts[o])
+	}
 
 	if err := scanner.Err(); err != nil {
 		log.Fatal(err)
@@ -135,28 +193,30 @@ func getRangesFromLine(line string) (assigns []SectionAssignment, err error) {
 	return assigns, nil
 }
 
-// determineOverlap determines whether two assignments overlap partially, one contains
-// the other, or not at all
+// determineOverlap classifies how two assignments relate to each other: equal,
+// one containing the other, partially overlapping, adjacent or disjoint
 func determineOverlap(a, b SectionAssignment) OverlapType {
-	if a.Start < b.Start {
-		if a.End < b.Start {
-			return None
-		}
-		if a.End < b.End {
-			return Partial
-		}
-		return Containing
+	if a == b {
+		return Equal
+	}
+	if a.Start <= b.Start && a.End >= b.End {
+		return AContainsB
+	}
+	if b.Start <= a.Start && b.End >= a.End {
+		return BContainsA
 	}
 
+	// Order the assignments by start so only one side needs checking
+	first, second := a, b
 	if b.Start < a.Start {
-		if b.End < a.Start {
-			return None
-		}
-		if b.End < a.End {
-			return Partial
-		}
-		return Containing
+		first, second = b, a
 	}
-
-	return Containing
+	if first.End >= second.Start {
+		return Partial
+	}
+	// Compare against Start-1 rather than End+1 to avoid overflow
+	if first.End == second.Start-1 {
+		return Adjacent
+	}
+	return Disjoint
 }
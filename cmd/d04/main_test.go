@@ -1,6 +1,10 @@
 package main
 
-import "testing"
+import (
+	"regexp"
+	"testing"
+	"testing/quick"
+)
 
 func TestPassingLines(t *testing.T) {
 	goodLines := []string{
@@ -62,7 +66,7 @@ func TestFullyOverlapping(t *testing.T) {
 		{{5002, 8002}, {3008, 9104}},
 	}
 	for _, a := range assigns {
-		if determineOverlap(a[0], a[1]) != Containing {
+		if !determineOverlap(a[0], a[1]).IsContaining() {
 			t.Errorf("%v and %v should overlap, but received false", a[0], a[1])
 		}
 	}
@@ -77,12 +81,147 @@ func TestNotFullyOverlapping(t *testing.T) {
 		{{555, 666}, {777, 888}},
 	}
 	for _, a := range assigns {
-		if determineOverlap(a[0], a[1]) == Containing {
+		if determineOverlap(a[0], a[1]).IsContaining() {
 			t.Errorf("%v and %v do not overlap, but received true", a[0], a[1])
 		}
 	}
 }
 
+func TestDetermineOverlapCategories(t *testing.T) {
+	cases := []struct {
+		a, b SectionAssignment
+		want OverlapType
+	}{
+		{SectionAssignment{2, 4}, SectionAssignment{6, 8}, Disjoint},
+		{SectionAssignment{6, 8}, SectionAssignment{2, 4}, Disjoint},
+		{SectionAssignment{2, 3}, SectionAssignment{4, 5}, Adjacent},
+		{SectionAssignment{4, 5}, SectionAssignment{2, 3}, Adjacent},
+		{SectionAssignment{5, 7}, SectionAssignment{7, 9}, Partial},
+		{SectionAssignment{2, 6}, SectionAssignment{4, 8}, Partial},
+		{SectionAssignment{2, 8}, SectionAssignment{3, 7}, AContainsB},
+		{SectionAssignment{56, 68}, SectionAssignment{56, 59}, AContainsB},
+		{SectionAssignment{6, 6}, SectionAssignment{4, 6}, BContainsA},
+		{SectionAssignment{5002, 8002}, SectionAssignment{3008, 9104}, BContainsA},
+		{SectionAssignment{3, 3}, SectionAssignment{3, 3}, Equal},
+		{SectionAssignment{12, 28}, SectionAssignment{12, 28}, Equal},
+		{SectionAssignment{0, 0}, SectionAssignment{1, 1}, Adjacent},
+		{SectionAssignment{0, ^uint64(0)}, SectionAssignment{^uint64(0), ^uint64(0)}, AContainsB},
+	}
+	for _, c := range cases {
+		if got := determineOverlap(c.a, c.b); got != c.want {
+			t.Errorf("%v and %v should be %v but were %v", c.a, c.b, c.want, got)
+		}
+	}
+}
+
+// assignmentFrom builds a valid SectionAssignment from two arbitrary bounds.
+// Small bounds make equal, adjacent and containing pairs common.
+func assignmentFrom(x, y uint8) SectionAssignment {
+	x, y = x%16, y%16
+	if x > y {
+		x, y = y, x
+	}
+	return SectionAssignment{uint64(x), uint64(y)}
+}
+
+func TestDetermineOverlapSymmetry(t *testing.T) {
+	symmetric := func(x1, y1, x2, y2 uint8) bool {
+		a, b := assignmentFrom(x1, y1), assignmentFrom(x2, y2)
+		return determineOverlap(a, b) == determineOverlap(b, a).Mirror()
+	}
+	if err := quick.Check(symmetric, &quick.Config{MaxCount: 5000}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDetermineOverlapMatchesSectionSets(t *testing.T) {
+	// Compare the classification against a brute-force view of the section sets
+	matchesSets := func(x1, y1, x2, y2 uint8) bool {
+		a, b := assignmentFrom(x1, y1), assignmentFrom(x2, y2)
+		inA := func(s uint64) bool { return s >= a.Start && s <= a.End }
+		inB := func(s uint64) bool { return s >= b.Start && s <= b.End }
+		var shared, onlyA, onlyB int
+		for s := uint64(0); s < 16; s++ {
+			switch {
+			case inA(s) && inB(s):
+				shared++
+			case inA(s):
+				onlyA++
+			case inB(s):
+				onlyB++
+			}
+		}
+		got := determineOverlap(a, b)
+		switch {
+		case shared == 0:
+			touching := a.End+1 == b.Start || b.End+1 == a.Start
+			return (touching && got == Adjacent) || (!touching && got == Disjoint)
+		case onlyA == 0 && onlyB == 0:
+			return got == Equal
+		case onlyB == 0:
+			return got == AContainsB
+		case onlyA == 0:
+			return got == BContainsA
+		}
+		return got == Partial
+	}
+	if err := quick.Check(matchesSets, &quick.Config{MaxCount: 5000}); err != nil {
+		t.Error(err)
+	}
+}
+
+// leadingZeroRgx matches a number with a redundant leading zero, which parses
+// fine but cannot round-trip back to the exact same text
+var leadingZeroRgx = regexp.MustCompile(`(^|[-,])0[0-9]`)
+
+func FuzzGetRangesFromLine(f *testing.F) {
+	// Edge cases live in testdata/fuzz/FuzzGetRangesFromLine
+	seeds := []string{
+		"2-4,6-8",
+		"2-3,4-5",
+		"5-7,7-9",
+		"2-8,3-7",
+		"6-6,4-6",
+		"2-6,4-8",
+	}
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, line string) {
+		assigns, err := getRangesFromLine(line)
+		if err != nil {
+			return
+		}
+		if len(assigns) != 2 {
+			t.Fatalf("expected 2 assignments for %q but got %v", line, len(assigns))
+		}
+		for _, a := range assigns {
+			if a.End < a.Start {
+				t.Fatalf("assignment %v from %q ends before it starts", a, line)
+			}
+		}
+
+		// Formatting the ranges back out must give a line that parses identically
+		printed := assigns[0].String() + "," + assigns[1].String()
+		reparsed, err := getRangesFromLine(printed)
+		if err != nil {
+			t.Fatalf("printed line %q from %q did not parse: %v", printed, line, err)
+		}
+		if !checkSlicesEqual(assigns, reparsed) {
+			t.Fatalf("round trip of %q gave %v but expected %v", line, reparsed, assigns)
+		}
+		if !leadingZeroRgx.MatchString(line) && printed != line {
+			t.Fatalf("round trip of %q printed %q", line, printed)
+		}
+
+		// Classification must never panic and must agree with its mirror
+		o := determineOverlap(assigns[0], assigns[1])
+		if mirrored := determineOverlap(assigns[1], assigns[0]); mirrored != o.Mirror() {
+			t.Fatalf("%q is %v one way but %v the other", line, o, mirrored)
+		}
+	})
+}
+
 // checkSlicesEqual does a deep comparison on two SectionAssignment slices
 func checkSlicesEqual(a []SectionAssignment, b []SectionAssignment) bool {
 	if len(a) != len(b) {
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+const clearScreen = "\033[H\033[2J"
+
+// Animator draws the stacks after every Every-th move, either by redrawing a
+// terminal with ANSI escapes or by appending plain frames to a file
+type Animator struct {
+	Out   io.Writer
+	ANSI  bool
+	Delay time.Duration
+	Every int
+
+	moves int  // Number of moves seen so far
+	drawn bool // Whether the latest state has already been drawn
+}
+
+// isTerminal reports whether f looks like an interactive terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// Start draws the initial state of the stacks
+func (a *Animator) Start(stacks [][]string) error {
+	return a.draw(stacks, "initial state")
+}
+
+// Step records a completed move and draws it if it falls on the interval
+func (a *Animator) Step(stacks [][]string, cmd Command) error {
+	a.moves++
+	a.drawn = false
+	if a.Every > 1 && a.moves%a.Every != 0 {
+		return nil
+	}
+	return a.draw(stacks, fmt.Sprintf("move #%d: %v", a.moves, cmd))
+}
+
+// Finish draws the final state if the last move was skipped by the interval
+func (a *Animator) Finish(stacks [][]string) error {
+	if a.drawn {
+		return nil
+	}
+	return a.draw(stacks, fmt.Sprintf("final state after %d moves", a.moves))
+}
+
+func (a *Animator) draw(stacks [][]string, caption string) error {
+	var b strings.Builder
+	if a.ANSI {
+		b.WriteString(clearScreen)
+	} else {
+		fmt.Fprintf(&b, "--- %v ---\n", caption)
+	}
+	for _, line := range formatDiagram(stacks) {
+		b.WriteString(line)
+		b.WriteString("\n")
+	}
+	if a.ANSI {
+		fmt.Fprintf(&b, "\n%v\n", caption)
+	} else {
+		b.WriteString("\n")
+	}
+	if _, err := io.WriteString(a.Out, b.String()); err != nil {
+		return err
+	}
+	a.drawn = true
+	if a.ANSI && a.Delay > 0 {
+		time.Sleep(a.Delay)
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var ErrUnknownCrane = errors.New("unknown crane model")
+var ErrCraneNotReversible = errors.New("crane model cannot be run in reverse")
+
+// craneNames lists the names accepted by craneByName
+var craneNames = []string{"9000", "9001", "capacity:N", "reverse", "rotate:N"}
+
+// Crane moves crates between stacks. whence and whither are 0-indexed
+type Crane interface {
+	Name() string
+	Move(stacks [][]string, howMany, whence, whither int)
+}
+
+// ReversibleCrane is a crane whose moves can be undone by moving the same
+// number of crates back with the Inverse crane
+type ReversibleCrane interface {
+	Crane
+	Inverse() Crane
+}
+
+// CrateMover9000 lifts one crate at a time, so moved crates end up reversed
+type CrateMover9000 struct{}
+
+func (c CrateMover9000) Name() string {
+	return "9000"
+}
+
+func (c CrateMover9000) Move(stacks [][]string, howMany, whence, whither int) {
+	moveCratesIndiv(stacks, howMany, whence, whither)
+}
+
+func (c CrateMover9000) Inverse() Crane {
+	return c
+}
+
+// CrateMover9001 lifts all the crates at once, so they keep their order
+type CrateMover9001 struct{}
+
+func (c CrateMover9001) Name() string {
+	return "9001"
+}
+
+func (c CrateMover9001) Move(stacks [][]string, howMany, whence, whither int) {
+	moveCratesInBulk(stacks, howMany, whence, whither)
+}
+
+func (c CrateMover9001) Inverse() Crane {
+	return c
+}
+
+// CapacityCrane lifts at most Capacity crates at a time. Each lift keeps its
+// order, so a capacity of 1 behaves like the 9000
+type CapacityCrane struct {
+	Capacity int
+}
+
+func (c CapacityCrane) Name() string {
+	return fmt.Sprintf("capacity:%d", c.Capacity)
+}
+
+func (c CapacityCrane) Move(stacks [][]string, howMany, whence, whither int) {
+	for howMany > 0 {
+		lift := howMany
+		if lift > c.Capacity {
+			lift = c.Capacity
+		}
+		moveCratesInBulk(stacks, lift, whence, whither)
+		howMany -= lift
+	}
+}
+
+// ReversingCrane lifts all the crates at once and flips the batch over
+// before setting it down
+type ReversingCrane struct{}
+
+func (c ReversingCrane) Name() string {
+	return "reverse"
+}
+
+func (c ReversingCrane) Move(stacks [][]string, howMany, whence, whither int) {
+	dest := len(stacks[whither])
+	moveCratesInBulk(stacks, howMany, whence, whither)
+	batch := stacks[whither][dest:]
+	for i, j := 0, len(batch)-1; i < j; i, j = i+1, j-1 {
+		batch[i], batch[j] = batch[j], batch[i]
+	}
+}
+
+func (c ReversingCrane) Inverse() Crane {
+	return c
+}
+
+// RotatingCrane lifts all the crates at once and rotates the batch so the
+// bottom Turns crates move to the top before setting it down
+type RotatingCrane struct {
+	Turns int
+}
+
+func (c RotatingCrane) Name() string {
+	return fmt.Sprintf("rotate:%d", c.Turns)
+}
+
+func (c RotatingCrane) Move(stacks [][]string, howMany, whence, whither int) {
+	dest := len(stacks[whither])
+	moveCratesInBulk(stacks, howMany, whence, whither)
+	batch := stacks[whither][dest:]
+	if len(batch) == 0 {
+		return
+	}
+	turns := ((c.Turns % len(batch)) + len(batch)) % len(batch)
+	rotated := append(append([]string{}, batch[turns:]...), batch[:turns]...)
+	copy(batch, rotated)
+}
+
+func (c RotatingCrane) Inverse() Crane {
+	return RotatingCrane{Turns: -c.Turns}
+}
+
+// craneByName returns the crane model for a name such as "9001" or "capacity:3"
+func craneByName(name string) (Crane, error) {
+	model, arg, hasArg := strings.Cut(name, ":")
+	var n int
+	if hasArg {
+		var err error
+		n, err = strconv.Atoi(arg)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrUnknownCrane, name)
+		}
+	}
+	switch {
+	case model == "9000" && !hasArg:
+		return CrateMover9000{}, nil
+	case model == "9001" && !hasArg:
+		return CrateMover9001{}, nil
+	case model == "capacity" && hasArg && n > 0:
+		return CapacityCrane{Capacity: n}, nil
+	case model == "reverse" && !hasArg:
+		return ReversingCrane{}, nil
+	case model == "rotate" && hasArg && n > 0:
+		return RotatingCrane{Turns: n}, nil
+	}
+	return nil, fmt.Errorf("%w: %v", ErrUnknownCrane, name)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// formatDiagram renders the stacks in the same format as the puzzle input,
+// one line per level of crates with the footer line of stack numbers last.
+// Columns are widened to fit the longest label or stack number, so single
+// rune labels with at most 9 stacks look exactly like the puzzle input.
+// Every line is padded to the full width so parseDiagram can read it back
+func formatDiagram(stacks [][]string) []string {
+	var height int
+	width := 3 // "[X]"
+	for _, stack := range stacks {
+		if len(stack) > height {
+			height = len(stack)
+		}
+		for _, label := range stack {
+			if w := utf8.RuneCountInString(label) + 2; w > width {
+				width = w
+			}
+		}
+	}
+	if w := len(strconv.Itoa(len(stacks))) + 2; w > width {
+		width = w
+	}
+
+	lines := make([]string, 0, height+1)
+	cells := make([]string, len(stacks))
+	for level := height - 1; level >= 0; level-- {
+		for j, stack := range stacks {
+			if level < len(stack) {
+				cells[j] = padCell("["+stack[level]+"]", width, 0)
+			} else {
+				cells[j] = padCell("", width, 0)
+			}
+		}
+		lines = append(lines, strings.Join(cells, " "))
+	}
+
+	// Line each number up with the second rune of the column so it falls
+	// within the brackets of even the narrowest crate
+	for j := range stacks {
+		cells[j] = padCell(strconv.Itoa(j+1), width, 1)
+	}
+	lines = append(lines, strings.Join(cells, " "))
+	return lines
+}
+
+// padCell places s at offset within a cell of the given width
+func padCell(s string, width, offset int) string {
+	right := width - offset - utf8.RuneCountInString(s)
+	if right < 0 {
+		right = 0
+	}
+	return strings.Repeat(" ", offset) + s + strings.Repeat(" ", right)
+}
+
+// writeDiagram writes the stacks to w in the puzzle input format, so the
+// output can be read back with parseDiagram or used as the top of an input file
+func writeDiagram(w io.Writer, stacks [][]string) error {
+	for _, line := range formatDiagram(stacks) {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
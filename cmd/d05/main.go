@@ -8,27 +8,60 @@ import (
 	"log"
 	"os"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 )
 
 var ErrOversizedLine = errors.New("line exceeds maximum length")
 var ErrImproperlyFormattedLine = errors.New("improperly formatted line")
+var ErrStackDoesNotExist = errors.New("stack does not exist")
+var ErrNotEnoughCrates = errors.New("not enough crates on source stack")
 
 const MaxByesPerLine = 3 * 1024 // 3kB max line length
+const EmptyStackPlaceholder = "-"
+
 var commandRgx = regexp.MustCompile(`move (\d+) from (\d+) to (\d+)`)
+var crateRgx = regexp.MustCompile(`\[([^\[\]\s]+)\]`)
+var stackNumRgx = regexp.MustCompile(`\S+`)
+
+var crane Crane = CrateMover9000{}
 
-var partB bool
+var (
+	animate    bool
+	delay      time.Duration
+	every      int
+	framesPath string
+	savePath   string
+	maxMoves   int
+	reverse    bool
+)
 
 func main() {
 	// Parse flags
 	{
-		partBFlag := flag.Bool("b", false, "To switch to part b")
+		partBFlag := flag.Bool("b", false, "To switch to part b (same as -crane 9001)")
+		craneFlag := flag.String("crane", "", "Crane model to use: "+strings.Join(craneNames, ", "))
+		flag.BoolVar(&animate, "animate", false, "Draw the stacks after every move")
+		flag.DurationVar(&delay, "delay", 300*time.Millisecond, "Time between animation frames in a terminal")
+		flag.IntVar(&every, "every", 1, "Only draw every Nth move when animating")
+		flag.StringVar(&framesPath, "frames", "frames.txt", "File to write animation frames to when not in a terminal")
+		flag.StringVar(&savePath, "save", "", "File to write the final stack diagram to")
+		flag.IntVar(&maxMoves, "moves", -1, "Stop after this many moves, or with -reverse only undo this many from the end (negative for all of them)")
+		flag.BoolVar(&reverse, "reverse", false, "Treat the diagram as the final state and reconstruct the initial one")
 		flag.Parse()
-		if partBFlag != nil {
-			partB = *partBFlag
+		if partBFlag != nil && *partBFlag {
+			crane = CrateMover9001{}
+		}
+		if craneFlag != nil && *craneFlag != "" {
+			c, err := craneByName(*craneFlag)
+			if err != nil {
+				log.Fatal(err)
+			}
+			crane = c
 		}
-
 	}
 
 	// Check args
@@ -47,10 +80,14 @@ func main() {
 	// text file containing the diagram
 	dgrmLines := make([]string, 0)
 
+	// Line number within the file (1-indexed) for error reporting
+	var lineNum int
+
 	// Fill the dgrmLines stack
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
 		line := scanner.Text()
+		lineNum++
 
 		// Validate max line length
 		if len(line) > MaxByesPerLine {
@@ -67,79 +104,294 @@ func main() {
 		log.Fatal(err)
 	}
 
-	// Calculate how many stacks/piles of crates their are
-	numStacks := len(strings.Fields(dgrmLines[len(dgrmLines)-1]))
-
-	// 2D slice containing them
-	stacks := make([][]rune, numStacks)
+	stacks, err := parseDiagram(dgrmLines)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	// How long each line in the diagram should be (in runes)
-	lineLength := 4*numStacks - 1
+	if reverse {
+		// Every command has to be read before the last one can be undone
+		firstLine := lineNum + 1
+		cmds := make([]Command, 0)
+		for scanner.Scan() {
+			line := scanner.Text()
+			lineNum++
+			if len(line) > MaxByesPerLine {
+				log.Fatalf("line %d: %v", lineNum, ErrOversizedLine)
+			}
+			cmd, err := parseCommand(line)
+			if err != nil {
+				log.Fatalf("line %d: %v", lineNum, err)
+			}
+			cmds = append(cmds, cmd)
+		}
+		if err := scanner.Err(); err != nil {
+			log.Fatal(err)
+		}
+		cmds, firstLine = lastMoves(cmds, maxMoves, firstLine)
+		if err := reverseReplay(stacks, crane, cmds, firstLine); err != nil {
+			log.Fatal(err)
+		}
+		if savePath != "" {
+			saveDiagram(savePath, stacks)
+		}
+		if err := writeDiagram(os.Stdout, stacks); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
-	// unwind the stack of diagram lines to fill the 2D slice with runes
-	for i := len(dgrmLines) - 2; i >= 0; i-- {
-		runes := []rune(dgrmLines[i])
-		if len(runes) != lineLength {
-			log.Fatal(ErrImproperlyFormattedLine)
-		}
-		for j := 0; j < numStacks; j++ {
-			r := runes[j*4+1]
-			if r != ' ' {
-				stacks[j] = append(stacks[j], r)
+	var animator *Animator
+	if animate {
+		animator = &Animator{
+			Out:   os.Stdout,
+			ANSI:  isTerminal(os.Stdout),
+			Delay: delay,
+			Every: every,
+		}
+		if !animator.ANSI {
+			framesFile, err := os.Create(framesPath)
+			if err != nil {
+				log.Fatal(err)
 			}
+			defer framesFile.Close()
+			animator.Out = framesFile
+		}
+		if err := animator.Start(stacks); err != nil {
+			log.Fatal(err)
 		}
 	}
 
 	// Iterate through the command lines to mutate the 2D slice data
-	for scanner.Scan() {
+	for moves := 0; maxMoves < 0 || moves < maxMoves; moves++ {
+		if !scanner.Scan() {
+			break
+		}
 		line := scanner.Text()
+		lineNum++
 
 		// Validate max line length
 		if len(line) > MaxByesPerLine {
-			log.Fatal(ErrOversizedLine)
-		}
-		matches := commandRgx.FindStringSubmatch(line)
-		if len(matches) != 4 {
-			log.Fatal(ErrImproperlyFormattedLine)
+			log.Fatalf("line %d: %v", lineNum, ErrOversizedLine)
 		}
-		howMany, err := strconv.Atoi(matches[1])
+		cmd, err := parseCommand(line)
 		if err != nil {
-			log.Fatal(ErrImproperlyFormattedLine)
+			log.Fatalf("line %d: %v", lineNum, err)
 		}
-		whence, err := strconv.Atoi(matches[2])
-		if err != nil {
-			log.Fatal(ErrImproperlyFormattedLine)
-		}
-		whither, err := strconv.Atoi(matches[3])
-		if err != nil {
-			log.Fatal(ErrImproperlyFormattedLine)
+		if err := applyCommand(stacks, crane, cmd, lineNum); err != nil {
+			log.Fatal(err)
 		}
-		if !partB {
-			moveCratesIndiv(stacks, howMany, whence-1, whither-1)
-		} else {
-			moveCratesInBulk(stacks, howMany, whence-1, whither-1)
+		if animator != nil {
+			if err := animator.Step(stacks, cmd); err != nil {
+				log.Fatal(err)
+			}
 		}
 	}
 	if err := scanner.Err(); err != nil {
 		log.Fatal(err)
 	}
+	if animator != nil {
+		if err := animator.Finish(stacks); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if savePath != "" {
+		saveDiagram(savePath, stacks)
+	}
+
+	// Print out the last item in each sub-slice of the finalized 2D slice data
+	fmt.Println(topCrates(stacks))
+	fmt.Println("-----------------")
 
-	// Iterate through finalized 2D slice data to print out the last item in each sub-slice
-	for i := 0; i < numStacks; i++ {
-		fmt.Printf("%v", string(stacks[i][len(stacks[i])-1]))
+}
+
+// saveDiagram writes the stacks to a new file at path
+func saveDiagram(path string, stacks [][]string) {
+	saveFile, err := os.Create(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := writeDiagram(saveFile, stacks); err != nil {
+		log.Fatal(err)
 	}
-	fmt.Println("\n-----------------")
+	if err := saveFile.Close(); err != nil {
+		log.Fatal(err)
+	}
+}
 
+// Command is a single parsed "move X from Y to Z" line. Stack numbers are
+// 1-indexed as they appear in the input
+type Command struct {
+	HowMany int
+	Whence  int
+	Whither int
+}
+
+func (c Command) String() string {
+	return fmt.Sprintf("move %d from %d to %d", c.HowMany, c.Whence, c.Whither)
+}
+
+// MoveError reports a command that cannot be carried out against the
+// current state of the stacks
+type MoveError struct {
+	Line    int
+	Command Command
+	Sizes   []int // Size of each stack when the command was attempted
+	Err     error
+}
+
+func (e *MoveError) Error() string {
+	return fmt.Sprintf("line %d: %v: %v (stack sizes: %v)", e.Line, e.Command, e.Err, e.Sizes)
+}
+
+func (e *MoveError) Unwrap() error {
+	return e.Err
+}
+
+// validateCommand checks that a command refers to existing stacks and that
+// the source stack holds enough crates
+func validateCommand(stacks [][]string, cmd Command) error {
+	if cmd.Whence < 1 || cmd.Whence > len(stacks) || cmd.Whither < 1 || cmd.Whither > len(stacks) {
+		return ErrStackDoesNotExist
+	}
+	if cmd.HowMany > len(stacks[cmd.Whence-1]) {
+		return ErrNotEnoughCrates
+	}
+	return nil
+}
+
+// applyCommand validates a command and then carries it out with the given crane.
+// Moving crates onto the stack they came from leaves it as it was.
+// lineNum is only used for error reporting
+func applyCommand(stacks [][]string, c Crane, cmd Command, lineNum int) error {
+	if err := validateCommand(stacks, cmd); err != nil {
+		sizes := make([]int, len(stacks))
+		for i := range stacks {
+			sizes[i] = len(stacks[i])
+		}
+		return &MoveError{
+			Line:    lineNum,
+			Command: cmd,
+			Sizes:   sizes,
+			Err:     err,
+		}
+	}
+	if cmd.Whence != cmd.Whither {
+		c.Move(stacks, cmd.HowMany, cmd.Whence-1, cmd.Whither-1)
+	}
+	return nil
+}
+
+// topCrates returns the top crate of every stack, using EmptyStackPlaceholder
+// for stacks that have no crates left. Labels are run together as in the
+// puzzle answer unless one of them is longer than a single rune
+func topCrates(stacks [][]string) string {
+	tops := make([]string, len(stacks))
+	sep := ""
+	for i, stack := range stacks {
+		if len(stack) == 0 {
+			tops[i] = EmptyStackPlaceholder
+			continue
+		}
+		tops[i] = stack[len(stack)-1]
+		if utf8.RuneCountInString(tops[i]) > 1 {
+			sep = " "
+		}
+	}
+	return strings.Join(tops, sep)
+}
+
+// parseDiagram converts the lines of the crate diagram (including the footer
+// line of stack numbers) into a 2D slice with the top crate of each stack last.
+// Each crate belongs to the stack whose number in the footer sits within its
+// brackets, so columns may be any width and lines may omit trailing spaces
+func parseDiagram(dgrmLines []string) ([][]string, error) {
+	if len(dgrmLines) == 0 {
+		return nil, ErrImproperlyFormattedLine
+	}
+
+	// Find the centre column (in runes) of every stack number in the footer
+	footer := dgrmLines[len(dgrmLines)-1]
+	var centres []int
+	for i, loc := range stackNumRgx.FindAllStringIndex(footer, -1) {
+		if num, err := strconv.Atoi(footer[loc[0]:loc[1]]); err != nil || num != i+1 {
+			return nil, ErrImproperlyFormattedLine
+		}
+		start := utf8.RuneCountInString(footer[:loc[0]])
+		width := utf8.RuneCountInString(footer[loc[0]:loc[1]])
+		centres = append(centres, start+(width-1)/2)
+	}
+
+	// 2D slice containing them
+	stacks := make([][]string, len(centres))
+
+	// unwind the stack of diagram lines to fill the 2D slice with labels
+	for i := len(dgrmLines) - 2; i >= 0; i-- {
+		line := dgrmLines[i]
+		locs := crateRgx.FindAllStringSubmatchIndex(line, -1)
+
+		// Anything outside of the crates must be blank
+		var prev int
+		for _, loc := range locs {
+			if strings.TrimSpace(line[prev:loc[0]]) != "" {
+				return nil, ErrImproperlyFormattedLine
+			}
+			prev = loc[1]
+		}
+		if strings.TrimSpace(line[prev:]) != "" {
+			return nil, ErrImproperlyFormattedLine
+		}
+
+		filled := make([]bool, len(stacks))
+		for _, loc := range locs {
+			first := utf8.RuneCountInString(line[:loc[0]])
+			last := first + utf8.RuneCountInString(line[loc[0]:loc[1]]) - 1
+			j := sort.SearchInts(centres, first)
+			if j == len(centres) || centres[j] > last || filled[j] {
+				return nil, ErrImproperlyFormattedLine
+			}
+			// A crate can't float above an empty spot
+			if len(stacks[j]) != len(dgrmLines)-2-i {
+				return nil, ErrImproperlyFormattedLine
+			}
+			filled[j] = true
+			stacks[j] = append(stacks[j], line[loc[2]:loc[3]])
+		}
+	}
+	return stacks, nil
+}
+
+// parseCommand extracts the crate count and the source and destination
+// stacks from a command line
+func parseCommand(line string) (cmd Command, err error) {
+	matches := commandRgx.FindStringSubmatch(line)
+	if len(matches) != 4 {
+		return cmd, ErrImproperlyFormattedLine
+	}
+	cmd.HowMany, err = strconv.Atoi(matches[1])
+	if err != nil {
+		return cmd, ErrImproperlyFormattedLine
+	}
+	cmd.Whence, err = strconv.Atoi(matches[2])
+	if err != nil {
+		return cmd, ErrImproperlyFormattedLine
+	}
+	cmd.Whither, err = strconv.Atoi(matches[3])
+	if err != nil {
+		return cmd, ErrImproperlyFormattedLine
+	}
+	return cmd, nil
 }
 
-func moveCratesIndiv(stacks [][]rune, howMany, whence, whither int) {
+func moveCratesIndiv(stacks [][]string, howMany, whence, whither int) {
 	for i := 0; i < howMany; i++ {
 		lastIdx := len(stacks[whence]) - 1
 		stacks[whither] = append(stacks[whither], stacks[whence][lastIdx])
 		stacks[whence] = stacks[whence][:lastIdx]
 	}
 }
-func moveCratesInBulk(stacks [][]rune, howMany, whence, whither int) {
+func moveCratesInBulk(stacks [][]string, howMany, whence, whither int) {
 	whenceLen := len(stacks[whence])
 	stacks[whither] = append(stacks[whither], stacks[whence][whenceLen-howMany:whenceLen]...)
 	stacks[whence] = stacks[whence][:whenceLen-howMany]
@@ -0,0 +1,430 @@
+package main
+
+import (
+	"errors"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+var exampleDiagram = []string{
+	"    [D]    ",
+	"[N] [C]    ",
+	"[Z] [M] [P]",
+	" 1   2   3 ",
+}
+
+var exampleCommands = []string{
+	"move 1 from 2 to 1",
+	"move 3 from 1 to 3",
+	"move 2 from 2 to 1",
+	"move 1 from 1 to 2",
+}
+
+// runExample applies the example commands to the example diagram with the
+// given crane and returns the resulting stacks
+func runExample(t *testing.T, c Crane) [][]string {
+	stacks, err := parseDiagram(exampleDiagram)
+	if err != nil {
+		t.Fatalf("Could not parse example diagram: %v", err)
+	}
+	for i, line := range exampleCommands {
+		cmd, err := parseCommand(line)
+		if err != nil {
+			t.Fatalf("Could not parse command %v: %v", line, err)
+		}
+		if err := applyCommand(stacks, c, cmd, i+1); err != nil {
+			t.Fatalf("Could not apply command %v: %v", line, err)
+		}
+	}
+	return stacks
+}
+
+func TestParseDiagram(t *testing.T) {
+	stacks, err := parseDiagram(exampleDiagram)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"ZN", "MCD", "P"}
+	if len(stacks) != len(expected) {
+		t.Fatalf("Expected %v stacks but got %v", len(expected), len(stacks))
+	}
+	for i := range expected {
+		if strings.Join(stacks[i], "") != expected[i] {
+			t.Errorf("Stack %v should have been %v but was %v", i+1, expected[i], stacks[i])
+		}
+	}
+}
+
+func TestFailingCommands(t *testing.T) {
+	evilLines := []string{
+		"",
+		"move 1 from 2",
+		"move one from 2 to 1",
+		"move -1 from 2 to 1",
+		"move 99999999999999999999 from 2 to 1",
+	}
+	for _, line := range evilLines {
+		if _, err := parseCommand(line); err == nil {
+			t.Errorf("Validation should not have passed for line: %v", line)
+		}
+	}
+}
+
+func TestCraneModels(t *testing.T) {
+	cranes := []struct {
+		crane    Crane
+		expected []string
+	}{
+		{CrateMover9000{}, []string{"C", "M", "PDNZ"}},
+		{CrateMover9001{}, []string{"M", "C", "PZND"}},
+		{CapacityCrane{Capacity: 1}, []string{"C", "M", "PDNZ"}},
+		{CapacityCrane{Capacity: 2}, []string{"M", "C", "PNDZ"}},
+		{CapacityCrane{Capacity: 3}, []string{"M", "C", "PZND"}},
+		{ReversingCrane{}, []string{"C", "M", "PDNZ"}},
+		{RotatingCrane{Turns: 0}, []string{"M", "C", "PZND"}},
+		{RotatingCrane{Turns: 1}, []string{"C", "M", "PNDZ"}},
+		{RotatingCrane{Turns: -1}, []string{"C", "M", "PDZN"}},
+	}
+	for _, c := range cranes {
+		stacks := runExample(t, c.crane)
+		for i := range c.expected {
+			if strings.Join(stacks[i], "") != c.expected[i] {
+				t.Errorf("Crane %v: stack %v should have been %v but was %v",
+					c.crane.Name(), i+1, c.expected[i], stacks[i])
+			}
+		}
+	}
+}
+
+func TestCraneByName(t *testing.T) {
+	for _, name := range []string{"9000", "9001", "capacity:4", "reverse", "rotate:2"} {
+		c, err := craneByName(name)
+		if err != nil {
+			t.Errorf("Expected crane for name %v but got error: %v", name, err)
+			continue
+		}
+		if c.Name() != name {
+			t.Errorf("Crane for %v reported name %v", name, c.Name())
+		}
+	}
+	for _, name := range []string{"", "9002", "capacity", "capacity:0", "capacity:x", "reverse:1", "rotate", "rotate:0", "rotate:-1"} {
+		if _, err := craneByName(name); err == nil {
+			t.Errorf("Expected error for crane name %v", name)
+		}
+	}
+}
+
+func TestInvalidCommands(t *testing.T) {
+	cases := []struct {
+		cmd      Command
+		expected error
+	}{
+		{Command{HowMany: 3, Whence: 1, Whither: 2}, ErrNotEnoughCrates},
+		{Command{HowMany: 1, Whence: 3, Whither: 2}, nil},
+		{Command{HowMany: 2, Whence: 3, Whither: 2}, ErrNotEnoughCrates},
+		{Command{HowMany: 1, Whence: 0, Whither: 2}, ErrStackDoesNotExist},
+		{Command{HowMany: 1, Whence: 1, Whither: 4}, ErrStackDoesNotExist},
+		{Command{HowMany: 1, Whence: 2, Whither: 2}, nil},
+		{Command{HowMany: 4, Whence: 2, Whither: 2}, ErrNotEnoughCrates},
+		{Command{HowMany: 0, Whence: 1, Whither: 2}, nil},
+	}
+	for i, c := range cases {
+		stacks, err := parseDiagram(exampleDiagram)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = applyCommand(stacks, CrateMover9000{}, c.cmd, i+1)
+		if !errors.Is(err, c.expected) {
+			t.Errorf("Expected %v for %v but got %v", c.expected, c.cmd, err)
+		}
+		if err == nil {
+			// Moving onto the same stack is a no-op
+			if initial, _ := parseDiagram(exampleDiagram); c.cmd.Whence == c.cmd.Whither && !checkStacksEqual(initial, stacks) {
+				t.Errorf("Expected %v to leave the stacks as %q but got %q", c.cmd, initial, stacks)
+			}
+			continue
+		}
+		var moveErr *MoveError
+		if !errors.As(err, &moveErr) {
+			t.Fatalf("Expected a MoveError for %v but got %T", c.cmd, err)
+		}
+		if moveErr.Line != i+1 || moveErr.Command != c.cmd || len(moveErr.Sizes) != 3 || moveErr.Sizes[1] != 3 {
+			t.Errorf("MoveError for %v is missing context: %+v", c.cmd, moveErr)
+		}
+	}
+}
+
+func TestTopCratesWithEmptyStacks(t *testing.T) {
+	stacks := [][]string{{"A", "B"}, {}, {"C"}}
+	if tops := topCrates(stacks); tops != "B-C" {
+		t.Errorf("Expected B-C but got %v", tops)
+	}
+	stacks = [][]string{{"A", "BC"}, {}, {"10"}}
+	if tops := topCrates(stacks); tops != "BC - 10" {
+		t.Errorf("Expected BC - 10 but got %v", tops)
+	}
+}
+
+func TestFormatDiagram(t *testing.T) {
+	stacks, err := parseDiagram(exampleDiagram)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := formatDiagram(stacks)
+	if strings.Join(lines, "\n") != strings.Join(exampleDiagram, "\n") {
+		t.Errorf("Expected diagram:\n%v\nbut got:\n%v", strings.Join(exampleDiagram, "\n"), strings.Join(lines, "\n"))
+	}
+
+	// Intermediate states must also parse back to the same stacks
+	for _, c := range []Crane{CrateMover9000{}, CrateMover9001{}} {
+		stacks := runExample(t, c)
+		parsed, err := parseDiagram(formatDiagram(stacks))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !checkStacksEqual(stacks, parsed) {
+			t.Errorf("Crane %v: round trip of %q gave %q", c.Name(), stacks, parsed)
+		}
+	}
+
+	// With no crates at all only the footer is left
+	if lines := formatDiagram([][]string{{}, {}}); len(lines) != 1 || lines[0] != " 1   2 " {
+		t.Errorf("Expected only the footer line but got %q", lines)
+	}
+}
+
+// checkStacksEqual does a deep comparison on two sets of stacks
+func checkStacksEqual(a, b [][]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if len(a[i]) != len(b[i]) {
+			return false
+		}
+		for j := range a[i] {
+			if a[i][j] != b[i][j] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func TestDiagramRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(5))
+	const labels = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	for n := 0; n < 200; n++ {
+		// Random states with 1-12 stacks, including empty ones, and
+		// sometimes with labels of up to 3 runes
+		maxLabel := 1 + r.Intn(3)
+		stacks := make([][]string, 1+r.Intn(12))
+		for i := range stacks {
+			stacks[i] = make([]string, r.Intn(8))
+			for j := range stacks[i] {
+				label := make([]byte, 1+r.Intn(maxLabel))
+				for k := range label {
+					label[k] = labels[r.Intn(len(labels))]
+				}
+				stacks[i][j] = string(label)
+			}
+		}
+
+		var b strings.Builder
+		if err := writeDiagram(&b, stacks); err != nil {
+			t.Fatal(err)
+		}
+		printed := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+		parsed, err := parseDiagram(printed)
+		if err != nil {
+			t.Fatalf("Could not parse printed diagram:\n%v\n%v", b.String(), err)
+		}
+		if !checkStacksEqual(stacks, parsed) {
+			t.Fatalf("Round trip of %q gave %q", stacks, parsed)
+		}
+	}
+}
+
+func TestAnimatorEveryNthMove(t *testing.T) {
+	var out strings.Builder
+	a := &Animator{Out: &out, Every: 3}
+	stacks, err := parseDiagram(exampleDiagram)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Start(stacks); err != nil {
+		t.Fatal(err)
+	}
+	for i, line := range exampleCommands {
+		cmd, err := parseCommand(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := applyCommand(stacks, CrateMover9000{}, cmd, i+1); err != nil {
+			t.Fatal(err)
+		}
+		if err := a.Step(stacks, cmd); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := a.Finish(stacks); err != nil {
+		t.Fatal(err)
+	}
+
+	// Initial state, move 3 and the final state
+	if frames := strings.Count(out.String(), "---\n"); frames != 3 {
+		t.Errorf("Expected 3 frames but got %v:\n%v", frames, out.String())
+	}
+	if strings.Contains(out.String(), clearScreen) {
+		t.Errorf("Frames file should not contain ANSI escapes")
+	}
+}
+
+func TestReverseReplay(t *testing.T) {
+	cmds := make([]Command, len(exampleCommands))
+	for i, line := range exampleCommands {
+		cmd, err := parseCommand(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cmds[i] = cmd
+	}
+	initial, err := parseDiagram(exampleDiagram)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []Crane{CrateMover9000{}, CrateMover9001{}, ReversingCrane{}, RotatingCrane{Turns: 1}} {
+		stacks := runExample(t, c)
+		if err := reverseReplay(stacks, c, cmds, 1); err != nil {
+			t.Fatalf("Crane %v: %v", c.Name(), err)
+		}
+		if !checkStacksEqual(initial, stacks) {
+			t.Errorf("Crane %v: expected initial state %q but got %q", c.Name(), initial, stacks)
+		}
+	}
+}
+
+func TestPartialReverseReplay(t *testing.T) {
+	cmds := make([]Command, len(exampleCommands))
+	for i, line := range exampleCommands {
+		cmd, err := parseCommand(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cmds[i] = cmd
+	}
+
+	for n := 0; n <= len(cmds); n++ {
+		// Forward through the first len-n moves
+		expected, err := parseDiagram(exampleDiagram)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, cmd := range cmds[:len(cmds)-n] {
+			if err := applyCommand(expected, CrateMover9001{}, cmd, i+1); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// Back from the end through the last n
+		stacks := runExample(t, CrateMover9001{})
+		undo, firstLine := lastMoves(cmds, n, 1)
+		if len(undo) != n || firstLine != len(cmds)-n+1 {
+			t.Errorf("Expected %v moves from line %v but got %v from line %v", n, len(cmds)-n+1, len(undo), firstLine)
+		}
+		if err := reverseReplay(stacks, CrateMover9001{}, undo, firstLine); err != nil {
+			t.Fatalf("Undoing %v moves: %v", n, err)
+		}
+		if !checkStacksEqual(expected, stacks) {
+			t.Errorf("Undoing %v moves: expected %q but got %q", n, expected, stacks)
+		}
+	}
+	if undo, firstLine := lastMoves(cmds, -1, 6); len(undo) != len(cmds) || firstLine != 6 {
+		t.Errorf("Expected every move from line 6 but got %v from line %v", len(undo), firstLine)
+	}
+}
+
+func TestImpossibleReverseReplay(t *testing.T) {
+	cmds := []Command{
+		{HowMany: 1, Whence: 1, Whither: 2},
+		{HowMany: 3, Whence: 2, Whither: 3},
+	}
+
+	// Stack 3 only holds one crate, so the last move can't have delivered three
+	stacks := [][]string{{"A"}, {"B", "C"}, {"D"}}
+	err := reverseReplay(stacks, CrateMover9000{}, cmds, 6)
+	if !errors.Is(err, ErrImpossibleMove) {
+		t.Fatalf("Expected %v but got %v", ErrImpossibleMove, err)
+	}
+	var moveErr *MoveError
+	if !errors.As(err, &moveErr) || moveErr.Line != 7 || moveErr.Command != cmds[1] {
+		t.Errorf("Expected the error to point at line 7 and %v but got %v", cmds[1], err)
+	}
+
+	if err := reverseReplay(stacks, CapacityCrane{Capacity: 2}, cmds, 6); !errors.Is(err, ErrCraneNotReversible) {
+		t.Errorf("Expected %v but got %v", ErrCraneNotReversible, err)
+	}
+}
+
+func TestParseVariableWidthDiagram(t *testing.T) {
+	cases := []struct {
+		diagram  []string
+		expected [][]string
+	}{
+		// Trailing spaces trimmed by an editor
+		{
+			[]string{
+				"    [D]",
+				"[N] [C]",
+				"[Z] [M] [P]",
+				" 1   2   3",
+			},
+			[][]string{{"Z", "N"}, {"M", "C", "D"}, {"P"}},
+		},
+		// Multi-character labels
+		{
+			[]string{
+				"     [10]",
+				"[AB] [C]  [D]",
+				" 1    2    3",
+			},
+			[][]string{{"AB"}, {"C", "10"}, {"D"}},
+		},
+		// More than nine stacks
+		{
+			[]string{
+				"[A]                                          [J]",
+				" 1    2    3    4    5    6    7    8    9    10",
+			},
+			[][]string{{"A"}, {}, {}, {}, {}, {}, {}, {}, {}, {"J"}},
+		},
+	}
+	for _, c := range cases {
+		stacks, err := parseDiagram(c.diagram)
+		if err != nil {
+			t.Errorf("Could not parse diagram %q: %v", c.diagram, err)
+			continue
+		}
+		if !checkStacksEqual(c.expected, stacks) {
+			t.Errorf("Expected %q but got %q", c.expected, stacks)
+		}
+	}
+}
+
+func TestFailingDiagrams(t *testing.T) {
+	evilDiagrams := [][]string{
+		{},
+		{"[A] [B]", " 1   3 "},
+		{"[A] [B] [C]", " 1   2 "},
+		{"[A] B", " 1   2 "},
+		{"  [A]", " 1   2 "},
+		{"[A]    ", "    [B]", " 1   2 "},
+	}
+	for _, d := range evilDiagrams {
+		if _, err := parseDiagram(d); err == nil {
+			t.Errorf("Validation should not have passed for diagram: %q", d)
+		}
+	}
+}
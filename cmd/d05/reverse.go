@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrImpossibleMove = errors.New("destination stack holds fewer crates than the move delivered")
+
+// reverseReplay undoes cmds, starting from the last one, to turn the final
+// state of the stacks back into the initial one. cmds are in the order they
+// appear in the input, with the first one on line firstLine.
+// A move is impossible if the stack it delivered to holds too few crates
+func reverseReplay(stacks [][]string, c Crane, cmds []Command, firstLine int) error {
+	rc, ok := c.(ReversibleCrane)
+	if !ok {
+		return fmt.Errorf("%w: %v", ErrCraneNotReversible, c.Name())
+	}
+	inverse := rc.Inverse()
+
+	for i := len(cmds) - 1; i >= 0; i-- {
+		undo := Command{
+			HowMany: cmds[i].HowMany,
+			Whence:  cmds[i].Whither,
+			Whither: cmds[i].Whence,
+		}
+		if err := applyCommand(stacks, inverse, undo, firstLine+i); err != nil {
+			// Report the move as written rather than the undo of it
+			if moveErr, ok := err.(*MoveError); ok {
+				moveErr.Command = cmds[i]
+				if moveErr.Err == ErrNotEnoughCrates {
+					moveErr.Err = ErrImpossibleMove
+				}
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// lastMoves returns the last n of cmds, or all of them if n is negative,
+// along with the line the first one returned is on. Undoing those from the
+// final state gives the state after the moves before them
+func lastMoves(cmds []Command, n, firstLine int) ([]Command, int) {
+	if n < 0 || n >= len(cmds) {
+		return cmds, firstLine
+	}
+	skipped := len(cmds) - n
+	return cmds[skipped:], firstLine + skipped
+}
@@ -0,0 +1,131 @@
+package main
+
+import "fmt"
+
+// Detector watches a stream one rune at a time for windows of distinct runes
+type Detector interface {
+	// Size is the number of distinct runes that make up a marker
+	Size() int
+	// Push adds the next rune of the stream and reports whether the most
+	// recent Size runes are now all distinct
+	Push(r rune) bool
+	// Window returns the current run of distinct runes, oldest first and
+	// capped at the most recent Size of them
+	Window() []rune
+}
+
+// RingDetector keeps the current run of distinct runes in a ring buffer and
+// compares each new rune against it, so each rune costs up to Size comparisons
+type RingDetector struct {
+	buffer []rune // buffer of whatever desired marker size
+	start  int    // The index within the buffer at which the current run of distint runes starts
+	length int    // The length of the current run of distinct runes stored in the buffer
+}
+
+func NewRingDetector(size int) *RingDetector {
+	return &RingDetector{
+		buffer: make([]rune, size),
+	}
+}
+
+func (d *RingDetector) Size() int {
+	return len(d.buffer)
+}
+
+func (d *RingDetector) Push(r rune) bool {
+	size := len(d.buffer)
+
+	// Loop over currently captured distinct runes to ensure
+	// the new addition doesn't match one of them
+	// If it does then increate the start to just past the match
+	// and decrease the length accordingly
+	for x := 0; x < d.length; x++ {
+		if d.buffer[(x+d.start)%size] == r {
+			d.start = (d.start + x + 1) % size
+			d.length = d.length - (x + 1)
+			break
+		}
+	}
+
+	// A full buffer has to drop its oldest rune to make room
+	if d.length == size {
+		d.start = (d.start + 1) % size
+		d.length--
+	}
+
+	// Add the new rune to the buffer and increment size
+	d.buffer[(d.start+d.length)%size] = r
+	d.length++
+
+	return d.length == size
+}
+
+func (d *RingDetector) Window() []rune {
+	size := len(d.buffer)
+	window := make([]rune, d.length)
+	for x := range window {
+		window[x] = d.buffer[(d.start+x)%size]
+	}
+	return window
+}
+
+// LastSeenDetector remembers the index at which every rune was last seen, so
+// each rune costs a single lookup no matter how big the window is
+type LastSeenDetector struct {
+	size     int
+	lastSeen map[rune]int // Index at which each rune was last seen
+	runStart int          // Index at which the current run of distinct runes starts
+	i        int          // Index of the next rune in the stream
+	recent   []rune       // Ring buffer of the last size runes, only read by Window
+}
+
+func NewLastSeenDetector(size int) *LastSeenDetector {
+	return &LastSeenDetector{
+		size:     size,
+		lastSeen: make(map[rune]int),
+		recent:   make([]rune, size),
+	}
+}
+
+func (d *LastSeenDetector) Size() int {
+	return d.size
+}
+
+func (d *LastSeenDetector) Push(r rune) bool {
+	if prev, ok := d.lastSeen[r]; ok && prev >= d.runStart {
+		d.runStart = prev + 1
+	}
+	d.lastSeen[r] = d.i
+	d.recent[d.i%d.size] = r
+	d.i++
+	return d.i-d.runStart >= d.size
+}
+
+func (d *LastSeenDetector) Window() []rune {
+	length := d.i - d.runStart
+	if length > d.size {
+		length = d.size
+	}
+	window := make([]rune, length)
+	for x := range window {
+		window[x] = d.recent[(d.i-length+x)%d.size]
+	}
+	return window
+}
+
+// detectorNames lists the names accepted by newDetector
+var detectorNames = []string{"ring", "lastseen"}
+
+// newDetector returns the detector with the given name for markers of the given size
+func newDetector(name string, size int) (Detector, error) {
+	if size < 1 {
+		return nil, ErrInvalidMarkerSize
+	}
+	switch name {
+	case "ring":
+		return NewRingDetector(size), nil
+	case "lastseen":
+		return NewLastSeenDetector(size), nil
+	}
+	return nil, fmt.Errorf("%w: %v", ErrUnknownDetector, name)
+}
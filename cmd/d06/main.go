@@ -2,29 +2,100 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"unicode"
+	"unicode/utf8"
 )
 
 var ErrEncounteredBadRune = errors.New("encountered bad rune")
 var ErrBadfile = errors.New("bad file")
+var ErrInvalidMarkerSize = errors.New("marker size must be at least 1")
+var ErrUnknownDetector = errors.New("unknown detector")
 
-var markerSize int = 4
+var (
+	markerSize int = 4
+	allMarkers bool
+	jsonOutput bool
+	detector   string
+	listenAddr string
+	network    string
+	bytesMode  bool
+	partB      bool
+	sizeSet    bool // Whether -size was given
+)
+
+const (
+	UnitRunes = "runes"
+	UnitBytes = "bytes"
+)
+
+// Marker is a position in the stream where the previous Size symbols are all
+// distinct. Symbols are runes, or bytes in byte mode
+type Marker struct {
+	Offset int    `json:"offset"` // Number of symbols read when the marker completed
+	Bytes  int    `json:"bytes"`  // Number of bytes read when the marker completed
+	Runes  int    `json:"runes"`  // Number of runes read when the marker completed
+	Marker string `json:"marker"` // Hex encoded in byte mode
+}
+
+// Run is a stretch of the stream in which no symbol repeats
+type Run struct {
+	Offset int `json:"offset"` // Index of the first symbol of the run
+	Bytes  int `json:"bytes"`  // Byte offset of the start of the run
+	Runes  int `json:"runes"`  // Rune offset of the start of the run
+	Length int `json:"length"` // Number of symbols in the run
+}
+
+// Report holds everything found while scanning a stream
+type Report struct {
+	Unit    string   `json:"unit"` // What a symbol is: UnitRunes or UnitBytes
+	Size    int      `json:"size"`
+	Markers []Marker `json:"markers"`
+	Longest Run      `json:"longest"`
+}
+
+// StreamReader is what scanStream needs to read a stream in either mode
+type StreamReader interface {
+	io.RuneReader
+	io.ByteReader
+}
 
 func main() {
 	// Parse flags
 	{
-		partBFlag := flag.Bool("b", false, "To switch to part b")
+		flag.BoolVar(&partB, "b", false, "To switch to part b (same as -size 14)")
+		sizeFlag := flag.Int("size", 0, "Number of distinct runes that make up a marker")
+		flag.BoolVar(&allMarkers, "all", false, "Report every marker instead of only the first")
+		flag.BoolVar(&jsonOutput, "json", false, "Print the results as JSON")
+		flag.StringVar(&detector, "detector", "lastseen", "Marker detector to use: "+strings.Join(detectorNames, ", "))
+		flag.StringVar(&listenAddr, "listen", "", "Run as a server listening on this address instead of reading a file. It reports start-of-packet and start-of-message markers, or only the one picked by -b or -size")
+		flag.StringVar(&network, "network", "tcp", "Network to listen on with -listen: tcp or unix")
+		flag.BoolVar(&bytesMode, "bytes", false, "Treat the stream as raw bytes instead of UTF-8 text")
 		flag.Parse()
-		if partBFlag != nil && *partBFlag {
+		if partB {
 			markerSize = 14
 		}
+		if sizeFlag != nil && *sizeFlag != 0 {
+			markerSize = *sizeFlag
+			sizeSet = true
+		}
+	}
 
+	if listenAddr != "" {
+		serve()
+		return
 	}
 
 	// Check args
@@ -39,52 +110,171 @@ func main() {
 	}
 	defer file.Close()
 
-	i := 0                             // Index of rune in transmission
-	buffer := make([]rune, markerSize) // buffer of whatever desired marker size
-	start := 0                         // The index within the buffer at which the current run of distint runes starts
-	length := 0                        // The length of the current run of distinct runes stored in the buffer
+	d, err := newDetector(detector, markerSize)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	report, err := scanStream(bufio.NewReader(file), d, allMarkers, bytesMode)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	for _, m := range report.Markers {
+		fmt.Printf("marker %v at %v\n", m.Marker, report.formatOffset(m.Offset, m.Bytes, m.Runes))
+	}
+	fmt.Printf("longest distinct run: %v %v at %v\n", report.Longest.Length, report.Unit,
+		report.formatOffset(report.Longest.Offset, report.Longest.Bytes, report.Longest.Runes))
+}
+
+// formatOffset describes an offset in the report's unit, adding the other
+// unit when the two differ
+func (r Report) formatOffset(offset, bytes, runes int) string {
+	if bytes == runes {
+		return fmt.Sprint(offset)
+	}
+	if r.Unit == UnitBytes {
+		return fmt.Sprintf("%v (%v runes)", offset, runes)
+	}
+	return fmt.Sprintf("%v (%v bytes)", offset, bytes)
+}
+
+// scanStream feeds the whole stream through the detector looking for markers,
+// stopping after the first one unless all is set. The longest run of distinct
+// symbols is always measured over the whole stream.
+// In byte mode every byte is a symbol and invalid UTF-8 is allowed. Runes are
+// then counted by their leading bytes, which is exact for valid UTF-8
+func scanStream(reader StreamReader, d Detector, all, bytesMode bool) (Report, error) {
+	report := Report{
+		Unit:    UnitRunes,
+		Size:    d.Size(),
+		Markers: make([]Marker, 0),
+	}
+	if bytesMode {
+		report.Unit = UnitBytes
+	}
+
+	// position is how far into the stream we are after a given symbol
+	type position struct {
+		index int
+		bytes int
+		runes int
+	}
+	var pos position
+
+	lastSeen := make(map[rune]position) // Position just after each symbol was last seen
+	var runStart position               // Position at which the current run of distinct symbols starts
 
-	reader := bufio.NewReader(file)
 	for {
-		// Read next rune
-		r, _, err := reader.ReadRune()
-		// Validate
-		if err != nil {
-			if err == io.EOF {
-				break
+		// Read next symbol
+		var r rune
+		if bytesMode {
+			b, err := reader.ReadByte()
+			if err != nil {
+				if err == io.EOF {
+					break
+				}
+				return report, ErrBadfile
 			}
-			log.Fatal(ErrBadfile)
-		}
-		if r == unicode.ReplacementChar {
-			log.Fatal(ErrEncounteredBadRune)
+			r = rune(b)
+			pos.bytes++
+			if utf8.RuneStart(b) {
+				pos.runes++
+			}
+		} else {
+			var size int
+			var err error
+			r, size, err = reader.ReadRune()
+			// Validate
+			if err != nil {
+				if err == io.EOF {
+					break
+				}
+				return report, ErrBadfile
+			}
+			if r == unicode.ReplacementChar {
+				return report, ErrEncounteredBadRune
+			}
+			pos.bytes += size
+			pos.runes++
 		}
+		pos.index++
 
-		// start main logic
-		// Loop over currently captured distinct runes to ensure
-		// the new addition doesn't match one of them
-		// If it does then increate the start to just past the match
-		// and decrease the length accordingly
-		for x := 0; x < length; x++ {
-			if buffer[(x+start)%markerSize] == r {
-				start = (start + x + 1) % markerSize
-				length = length - (x + 1)
-				break
+		// Track the longest run of distinct symbols over the whole stream
+		if prev, ok := lastSeen[r]; ok && prev.index > runStart.index {
+			runStart = prev
+		}
+		lastSeen[r] = pos
+		if length := pos.index - runStart.index; length > report.Longest.Length {
+			report.Longest = Run{
+				Offset: runStart.index,
+				Bytes:  runStart.bytes,
+				Runes:  runStart.runes,
+				Length: length,
 			}
 		}
 
-		// Add the new rune to the buffer and increment size
-		buffer[(start+length)%markerSize] = r
-		length++
+		// Once all markers have been found there's nothing left to check
+		if !all && len(report.Markers) > 0 {
+			continue
+		}
 
-		// Stop when we have a full marker
-		if length == markerSize {
-			break
+		// Record when we have a full marker
+		if d.Push(r) {
+			report.Markers = append(report.Markers, Marker{
+				Offset: pos.index,
+				Bytes:  pos.bytes,
+				Runes:  pos.runes,
+				Marker: formatWindow(d.Window(), bytesMode),
+			})
 		}
-		i++
-		// end main logic
 	}
-	for x := 0; x < markerSize; x++ {
-		fmt.Printf("%s", string(buffer[(start+x)%markerSize]))
+	return report, nil
+}
+
+// formatWindow turns a detector's window into text, hex encoding it in byte
+// mode where every rune holds a single byte
+func formatWindow(window []rune, bytesMode bool) string {
+	if !bytesMode {
+		return string(window)
+	}
+	raw := make([]byte, len(window))
+	for i := range window {
+		raw[i] = byte(window[i])
+	}
+	return hex.EncodeToString(raw)
+}
+
+// serve runs the marker detection server until interrupted
+func serve() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	l, err := net.Listen(network, listenAddr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("listening on %v %v", l.Addr().Network(), l.Addr())
+
+	s := NewServer()
+	s.Detector = detector
+	s.BytesMode = bytesMode
+	// -b and -size pick out the one marker a run over a file would look for
+	switch {
+	case partB:
+		s.PacketSize, s.MessageSize = 0, markerSize
+	case sizeSet:
+		s.PacketSize, s.MessageSize = markerSize, 0
+	}
+	if err := s.Serve(ctx, l); err != nil {
+		log.Fatal(err)
 	}
-	fmt.Printf("\nbuffer: length: %v\n", i+1)
 }
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var examples = []struct {
+	stream  string
+	packet  int // Offset of the first marker of size 4
+	message int // Offset of the first marker of size 14
+}{
+	{"mjqjpqmgbljsphdztnvjfqwrcgsmlb", 7, 19},
+	{"bvwbjplbgvbhsrlpgdmjqwftvncz", 5, 23},
+	{"nppdvjthqldpwncqszvftbrmjlhg", 6, 23},
+	{"nznrnfrfntjfmvfwmzdfjlvtqnbhcprsg", 10, 29},
+	{"zcfzfwzzqfrljwzlrfnpqdbhtmscgvjw", 11, 26},
+}
+
+// mustDetector returns the named detector or fails the test
+func mustDetector(tb testing.TB, name string, size int) Detector {
+	d, err := newDetector(name, size)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return d
+}
+
+func TestFirstMarker(t *testing.T) {
+	for _, name := range detectorNames {
+		for _, e := range examples {
+			for size, expected := range map[int]int{4: e.packet, 14: e.message} {
+				report, err := scanStream(strings.NewReader(e.stream), mustDetector(t, name, size), false, false)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(report.Markers) != 1 || report.Markers[0].Offset != expected {
+					t.Errorf("%v: expected a size %v marker at %v in %v but got %v", name, size, expected, e.stream, report.Markers)
+				}
+			}
+		}
+	}
+}
+
+func TestAllMarkers(t *testing.T) {
+	for _, name := range detectorNames {
+		report, err := scanStream(strings.NewReader("abcabcdaa"), mustDetector(t, name, 3), true, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := []Marker{
+			{Offset: 3, Bytes: 3, Runes: 3, Marker: "abc"},
+			{Offset: 4, Bytes: 4, Runes: 4, Marker: "bca"},
+			{Offset: 5, Bytes: 5, Runes: 5, Marker: "cab"},
+			{Offset: 6, Bytes: 6, Runes: 6, Marker: "abc"},
+			{Offset: 7, Bytes: 7, Runes: 7, Marker: "bcd"},
+			{Offset: 8, Bytes: 8, Runes: 8, Marker: "cda"},
+		}
+		if len(report.Markers) != len(expected) {
+			t.Fatalf("%v: expected %v but got %v", name, expected, report.Markers)
+		}
+		for i := range expected {
+			if report.Markers[i] != expected[i] {
+				t.Errorf("%v: expected %v but got %v", name, expected[i], report.Markers[i])
+			}
+		}
+		if report.Longest != (Run{Offset: 3, Bytes: 3, Runes: 3, Length: 4}) {
+			t.Errorf("%v: expected longest run of 4 at 3 but got %+v", name, report.Longest)
+		}
+	}
+}
+
+func TestFailingStreams(t *testing.T) {
+	if _, err := scanStream(strings.NewReader("abc\xffdef"), mustDetector(t, "ring", 4), false, false); err != ErrEncounteredBadRune {
+		t.Errorf("Expected %v but got %v", ErrEncounteredBadRune, err)
+	}
+	if _, err := newDetector("ring", 0); err != ErrInvalidMarkerSize {
+		t.Errorf("Expected %v but got %v", ErrInvalidMarkerSize, err)
+	}
+	if _, err := newDetector("quantum", 4); !errors.Is(err, ErrUnknownDetector) {
+		t.Errorf("Expected %v but got %v", ErrUnknownDetector, err)
+	}
+}
+
+func TestByteMode(t *testing.T) {
+	// Invalid UTF-8 that rune mode rejects
+	stream := "\xff\xfe\xff\x00\x01\x02"
+	if _, err := scanStream(strings.NewReader(stream), mustDetector(t, "lastseen", 3), false, false); err != ErrEncounteredBadRune {
+		t.Errorf("Expected %v in rune mode but got %v", ErrEncounteredBadRune, err)
+	}
+	report, err := scanStream(strings.NewReader(stream), mustDetector(t, "lastseen", 3), true, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Unit != UnitBytes || len(report.Markers) != 3 || report.Markers[0].Offset != 4 || report.Markers[0].Marker != "feff00" {
+		t.Errorf("Unexpected byte mode report: %+v", report)
+	}
+	if report.Longest.Offset != 1 || report.Longest.Length != 5 {
+		t.Errorf("Expected longest run of 5 at 1 but got %+v", report.Longest)
+	}
+}
+
+func TestMultiByteOffsets(t *testing.T) {
+	// "é" is two bytes, so byte and rune offsets drift apart after it
+	stream := "aéaébcd"
+	for _, bytesMode := range []bool{false, true} {
+		report, err := scanStream(strings.NewReader(stream), mustDetector(t, "ring", 3), false, bytesMode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(report.Markers) != 1 {
+			t.Fatalf("Expected one marker but got %+v", report.Markers)
+		}
+		m := report.Markers[0]
+		if bytesMode {
+			// Bytes 61 c3 a9 are already distinct
+			if m.Offset != 3 || m.Bytes != 3 || m.Runes != 2 || m.Marker != "61c3a9" {
+				t.Errorf("Unexpected byte mode marker: %+v", m)
+			}
+		} else if m.Offset != 5 || m.Bytes != 7 || m.Runes != 5 || m.Marker != "aéb" {
+			t.Errorf("Unexpected rune mode marker: %+v", m)
+		}
+	}
+}
+
+// randomStream returns n runes drawn from an alphabet of the given size
+func randomStream(r *rand.Rand, n, alphabet int) []rune {
+	stream := make([]rune, n)
+	for i := range stream {
+		stream[i] = rune(0x4e00 + r.Intn(alphabet))
+	}
+	return stream
+}
+
+func TestDetectorsAgree(t *testing.T) {
+	r := rand.New(rand.NewSource(6))
+	for n := 0; n < 100; n++ {
+		size := 1 + r.Intn(20)
+		stream := randomStream(r, 500, size+r.Intn(10))
+		ring := NewRingDetector(size)
+		lastSeen := NewLastSeenDetector(size)
+		for i, c := range stream {
+			a, b := ring.Push(c), lastSeen.Push(c)
+			if a != b {
+				t.Fatalf("Size %v at %v: ring reported %v but lastseen reported %v", size, i, a, b)
+			}
+			if string(ring.Window()) != string(lastSeen.Window()) {
+				t.Fatalf("Size %v at %v: ring window %q but lastseen window %q", size, i, string(ring.Window()), string(lastSeen.Window()))
+			}
+		}
+	}
+}
+
+func BenchmarkDetectors(b *testing.B) {
+	const streamLen = 1 << 20
+	for _, size := range []int{4, 14, 100, 1000} {
+		// An alphabet a little bigger than the window keeps markers rare
+		// enough that the ring buffer has to do real work
+		stream := randomStream(rand.New(rand.NewSource(int64(size))), streamLen, size*3/2)
+		for _, name := range detectorNames {
+			b.Run(fmt.Sprintf("%v/size=%v", name, size), func(b *testing.B) {
+				// Throughput is reported per rune rather than per byte
+				b.SetBytes(streamLen)
+				for n := 0; n < b.N; n++ {
+					d := mustDetector(b, name, size)
+					for _, c := range stream {
+						d.Push(c)
+					}
+				}
+			})
+		}
+	}
+}
+
+// startServer runs s on a new listener and returns its address along with a
+// function that shuts it down and reports the result of Serve
+func startServer(t *testing.T, s *Server, network, address string) (net.Addr, func() error) {
+	l, err := net.Listen(network, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Serve(ctx, l)
+	}()
+	return l.Addr(), func() error {
+		cancel()
+		return <-done
+	}
+}
+
+// sendStream writes a whole stream to the server and collects every event
+// it sends back
+func sendStream(addr net.Addr, stream string) ([]Event, error) {
+	conn, err := net.Dial(addr.Network(), addr.String())
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if _, err := conn.Write([]byte(stream)); err != nil {
+		return nil, err
+	}
+	// Signal the end of the stream without closing the read side
+	if err := conn.(interface{ CloseWrite() error }).CloseWrite(); err != nil {
+		return nil, err
+	}
+
+	events := make([]Event, 0)
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, scanner.Err()
+}
+
+func TestServerConcurrentClients(t *testing.T) {
+	addr, stop := startServer(t, NewServer(), "tcp", "127.0.0.1:0")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		e := examples[i%len(examples)]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			events, err := sendStream(addr, e.stream)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if len(events) != 2 ||
+				events[0].Event != EventStartOfPacket || events[0].Offset != e.packet ||
+				events[1].Event != EventStartOfMessage || events[1].Offset != e.message {
+				t.Errorf("Unexpected events for %v: %+v", e.stream, events)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := stop(); err != nil {
+		t.Errorf("Serve returned %v after cancellation", err)
+	}
+}
+
+func TestServerUnixSocket(t *testing.T) {
+	addr, stop := startServer(t, NewServer(), "unix", filepath.Join(t.TempDir(), "d06.sock"))
+	defer stop()
+
+	events, err := sendStream(addr, "abc\xffdef")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Event != EventError || events[0].Error != ErrEncounteredBadRune.Error() {
+		t.Errorf("Expected a bad rune error event but got %+v", events)
+	}
+}
+
+func TestServerDetectorSettings(t *testing.T) {
+	// Only a start-of-packet marker of 3 bytes, found in a stream that isn't
+	// valid UTF-8
+	s := NewServer()
+	s.PacketSize, s.MessageSize = 3, 0
+	s.BytesMode = true
+	s.Detector = "ring"
+	addr, stop := startServer(t, s, "tcp", "127.0.0.1:0")
+	defer stop()
+
+	events, err := sendStream(addr, "aa\xff\xfeb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Event != EventStartOfPacket || events[0].Offset != 4 || events[0].ByteOffset != 4 || events[0].Marker != "61fffe" {
+		t.Errorf("Expected a single start-of-packet at 4 but got %+v", events)
+	}
+
+	// Only a start-of-message marker, as with -b
+	s = NewServer()
+	s.PacketSize = 0
+	addr, stop = startServer(t, s, "tcp", "127.0.0.1:0")
+	defer stop()
+	e := examples[0]
+	events, err = sendStream(addr, e.stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Event != EventStartOfMessage || events[0].Offset != e.message {
+		t.Errorf("Expected a single start-of-message at %v but got %+v", e.message, events)
+	}
+
+	s.MessageSize = 0
+	if err := s.Serve(context.Background(), nil); !errors.Is(err, ErrInvalidMarkerSize) {
+		t.Errorf("Expected %v with no markers to look for but got %v", ErrInvalidMarkerSize, err)
+	}
+}
+
+func TestServerByteOffsets(t *testing.T) {
+	s := NewServer()
+	s.PacketSize, s.MessageSize = 3, 4
+	addr, stop := startServer(t, s, "tcp", "127.0.0.1:0")
+	defer stop()
+
+	// Every € is three bytes
+	events, err := sendStream(addr, "€€ab€c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Event{
+		{Event: EventStartOfPacket, Offset: 4, ByteOffset: 8, Marker: "€ab"},
+		{Event: EventStartOfMessage, Offset: 6, ByteOffset: 12, Marker: "ab€c"},
+	}
+	if fmt.Sprint(events) != fmt.Sprint(expected) {
+		t.Errorf("Expected %+v but got %+v", expected, events)
+	}
+}
+
+func TestServerEventsArriveBeforeEOF(t *testing.T) {
+	addr, stop := startServer(t, NewServer(), "tcp", "127.0.0.1:0")
+	defer stop()
+
+	conn, err := net.Dial(addr.Network(), addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// Keep the stream open after the first marker
+	if _, err := conn.Write([]byte("mjqjpqm")); err != nil {
+		t.Fatal(err)
+	}
+	var e Event
+	if err := json.NewDecoder(conn).Decode(&e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Event != EventStartOfPacket || e.Offset != 7 || e.Marker != "jpqm" {
+		t.Errorf("Expected start-of-packet at 7 but got %+v", e)
+	}
+}
+
+func TestServerCancelClosesConnections(t *testing.T) {
+	addr, stop := startServer(t, NewServer(), "tcp", "127.0.0.1:0")
+
+	conn, err := net.Dial(addr.Network(), addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("aaaa")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Serve must return even though the client never finishes its stream
+	if err := stop(); err != nil {
+		t.Errorf("Serve returned %v after cancellation", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Errorf("Expected the connection to be closed")
+	}
+}
+
+func TestServerListenerFailureClosesConnections(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- NewServer().Serve(context.Background(), l)
+	}()
+
+	conn, err := net.Dial(l.Addr().Network(), l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// Wait for an event so the connection is known to be handled
+	if _, err := conn.Write([]byte("mjqjpqm")); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.NewDecoder(conn).Decode(&Event{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Accept fails without the context being cancelled, while the client
+	// still has its stream open
+	l.Close()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Errorf("Expected Serve to return the Accept error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve did not return after its listener was closed")
+	}
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Errorf("Expected the connection to be closed")
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"unicode"
+)
+
+const (
+	EventStartOfPacket  = "start-of-packet"
+	EventStartOfMessage = "start-of-message"
+	EventError          = "error"
+)
+
+// Event is sent to a client as a single line of JSON
+type Event struct {
+	Event      string `json:"event"`
+	Offset     int    `json:"offset,omitempty"`     // Symbols read when the marker completed
+	ByteOffset int    `json:"byteOffset,omitempty"` // Bytes read when the marker completed
+	Marker     string `json:"marker,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Server reads datastreams from clients and reports the first start-of-packet
+// and start-of-message markers in each as soon as they are found
+type Server struct {
+	PacketSize  int    // 0 to skip start-of-packet markers
+	MessageSize int    // 0 to skip start-of-message markers
+	Detector    string // Name of the detector to use, see newDetector
+	BytesMode   bool   // Treat streams as raw bytes instead of UTF-8 text
+}
+
+func NewServer() *Server {
+	return &Server{
+		PacketSize:  4,
+		MessageSize: 14,
+		Detector:    "lastseen",
+	}
+}
+
+// Serve accepts connections on l until ctx is cancelled, handling each one in
+// its own goroutine, or until Accept fails. Either way it closes l and every
+// connection and waits for them to finish before returning
+func (s *Server) Serve(ctx context.Context, l net.Listener) error {
+	// Make sure the detector settings are usable before accepting anyone
+	if _, err := s.detectors(); err != nil {
+		return err
+	}
+
+	// Cancelling before waiting closes every connection, so Serve doesn't
+	// hang on clients that are still sending when Accept fails
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	// Unblock Accept once we're cancelled
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.handleConn(ctx, conn)
+		}()
+	}
+}
+
+// handleConn reads a single datastream until the client stops sending or ctx
+// is cancelled
+func (s *Server) handleConn(ctx context.Context, conn net.Conn) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	defer conn.Close()
+
+	// Closing the connection unblocks any pending read or write
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	enc := json.NewEncoder(conn)
+	err := s.watchStream(bufio.NewReader(conn), enc.Encode)
+	if err != nil && ctx.Err() == nil {
+		enc.Encode(Event{Event: EventError, Error: err.Error()})
+	}
+}
+
+// detectors returns a new detector for every marker the server looks for,
+// keyed by the event it triggers
+func (s *Server) detectors() (map[string]Detector, error) {
+	if s.PacketSize == 0 && s.MessageSize == 0 {
+		return nil, ErrInvalidMarkerSize
+	}
+	detectors := make(map[string]Detector)
+	for name, size := range map[string]int{EventStartOfPacket: s.PacketSize, EventStartOfMessage: s.MessageSize} {
+		if size == 0 {
+			continue
+		}
+		d, err := newDetector(s.Detector, size)
+		if err != nil {
+			return nil, err
+		}
+		detectors[name] = d
+	}
+	return detectors, nil
+}
+
+// watchStream feeds the stream through the packet and message detectors and
+// emits an event for the first marker each of them finds. The rest of the
+// stream is read and discarded so the client can finish sending
+func (s *Server) watchStream(reader StreamReader, emit func(any) error) error {
+	watching, err := s.detectors()
+	if err != nil {
+		return err
+	}
+
+	var bytes int
+	for i := 0; ; i++ {
+		var r rune
+		if s.BytesMode {
+			b, err := reader.ReadByte()
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					return nil
+				}
+				return ErrBadfile
+			}
+			r = rune(b)
+			bytes++
+		} else {
+			var size int
+			var err error
+			r, size, err = reader.ReadRune()
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					return nil
+				}
+				return ErrBadfile
+			}
+			if len(watching) > 0 && r == unicode.ReplacementChar {
+				return ErrEncounteredBadRune
+			}
+			bytes += size
+		}
+		if len(watching) == 0 {
+			continue
+		}
+		for _, name := range []string{EventStartOfPacket, EventStartOfMessage} {
+			d, ok := watching[name]
+			if !ok || !d.Push(r) {
+				continue
+			}
+			delete(watching, name)
+			err := emit(Event{
+				Event:      name,
+				Offset:     i + 1,
+				ByteOffset: bytes,
+				Marker:     formatWindow(d.Window(), s.BytesMode),
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
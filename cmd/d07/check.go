@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrUnrecognizedLine   = errors.New("unrecognized line")
+	ErrConflictingListing = errors.New("conflicting listing")
+	ErrUnlistedDirectory  = errors.New("directory was never listed so its size is unknown")
+	ErrUnknownDirectory   = errors.New("cd into a directory that was never seen")
+)
+
+// TranscriptMode decides what happens when a transcript is inconsistent
+type TranscriptMode int
+
+const (
+	// TranscriptStrict stops at the first issue and returns it as the error
+	TranscriptStrict TranscriptMode = iota
+	// TranscriptLenient carries on past every issue. Unrecognized lines are
+	// skipped, the first listing of a name wins and directories that are
+	// changed into without being seen are created
+	TranscriptLenient
+)
+
+// Issue is an inconsistency found in a transcript
+type Issue struct {
+	Line   int
+	Path   string
+	Detail string // Extra context such as the text of the line
+	Err    error  // One of the sentinel errors above
+}
+
+func (i Issue) Error() string {
+	msg := i.Err.Error()
+	if i.Line > 0 {
+		msg = fmt.Sprintf("line %d: %v", i.Line, msg)
+	}
+	if i.Path != "" {
+		msg += ": " + i.Path
+	}
+	if i.Detail != "" {
+		msg += " (" + i.Detail + ")"
+	}
+	return msg
+}
+
+func (i Issue) Unwrap() error {
+	return i.Err
+}
+
+// transcriptChecker replays a transcript into a FileSystem while keeping
+// track of what has been seen
+type transcriptChecker struct {
+	fs     *FileSystem
+	mode   TranscriptMode
+	issues []Issue
+	listed map[*Directory]bool
+	seenAt map[*Directory]int // Line where each directory first appeared, 0 for the root
+}
+
+// report records an issue and, in strict mode, returns it to stop the replay
+func (c *transcriptChecker) report(issue Issue) error {
+	c.issues = append(c.issues, issue)
+	if c.mode == TranscriptStrict {
+		return issue
+	}
+	return nil
+}
+
+// CheckTranscript builds up fs from a terminal session like ReadTranscript
+// and returns every issue it finds: lines that match no pattern, names listed
+// twice with different sizes or types, cd into names that were never seen
+// and directories that were never listed. Listing the same entry again and
+// blank lines are fine. In strict mode the first issue is also returned as the error, while
+// in lenient mode the error is only set for problems that can't be worked
+// around, such as cd into a regular file
+func CheckTranscript(r io.Reader, fs *FileSystem, mode TranscriptMode) ([]Issue, error) {
+	c := &transcriptChecker{
+		fs:     fs,
+		mode:   mode,
+		listed: map[*Directory]bool{},
+		seenAt: map[*Directory]int{fs.Root: 0},
+	}
+
+	var lineNum int
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		lineNum++
+		var err error
+		if strings.TrimSpace(line) == "" {
+			continue
+		} else if matches := CdRgx.FindStringSubmatch(line); len(matches) == 2 {
+			err = c.changeDirectory(lineNum, matches[1])
+		} else if matches := LsRgx.FindStringSubmatch(line); len(matches) == 1 {
+			c.listed[fs.CurrentDir] = true
+		} else if matches := FileRgx.FindStringSubmatch(line); len(matches) == 3 {
+			size, parseErr := strconv.ParseUint(matches[1], 10, 64)
+			if parseErr != nil {
+				return c.issues, fmt.Errorf("line %d: could not parse filesize: %v", lineNum, matches[1])
+			}
+			err = c.addEntry(lineNum, matches[2], &RegularFile{Size: size})
+		} else if matches := DirRgx.FindStringSubmatch(line); len(matches) == 2 {
+			err = c.addEntry(lineNum, matches[1], nil)
+		} else {
+			err = c.report(Issue{Line: lineNum, Err: ErrUnrecognizedLine, Detail: strconv.Quote(line)})
+		}
+		if err != nil {
+			return c.issues, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return c.issues, err
+	}
+
+	err := fs.Walk(func(path string, f File) error {
+		if dir, ok := f.(*Directory); ok && !c.listed[dir] {
+			return c.report(Issue{Line: c.seenAt[dir], Path: path, Err: ErrUnlistedDirectory})
+		}
+		return nil
+	})
+	return c.issues, err
+}
+
+// changeDirectory follows path one segment at a time so that in lenient mode
+// any directories that were never seen can be created along the way
+func (c *transcriptChecker) changeDirectory(lineNum int, path string) error {
+	if strings.HasPrefix(path, "/") {
+		c.fs.CurrentDir = c.fs.Root
+	}
+	for _, segment := range strings.Split(path, "/") {
+		if segment == "" || segment == "." {
+			continue
+		}
+		err := c.fs.ChangeDirectory(segment)
+		if !errors.Is(err, ErrDirectoryDoesNotExist) {
+			if err != nil {
+				return fmt.Errorf("line %d: %w", lineNum, err)
+			}
+			continue
+		}
+		issue := Issue{Line: lineNum, Path: joinPath(c.fs.CurrentDir.Path(), segment), Err: ErrUnknownDirectory}
+		if err := c.report(issue); err != nil {
+			return err
+		}
+		if err := c.fs.CreateDirectory(segment); err != nil {
+			return fmt.Errorf("line %d: %w", lineNum, err)
+		}
+		if err := c.fs.ChangeDirectory(segment); err != nil {
+			return fmt.Errorf("line %d: %w", lineNum, err)
+		}
+		c.seenAt[c.fs.CurrentDir] = lineNum
+	}
+	return nil
+}
+
+// addEntry adds a listed file, or a directory if file is nil, to the current
+// directory. An identical entry that is already there is left alone
+func (c *transcriptChecker) addEntry(lineNum int, name string, file *RegularFile) error {
+	path := joinPath(c.fs.CurrentDir.Path(), name)
+	switch existing := c.fs.CurrentDir.Children[name].(type) {
+	case nil:
+	case *Directory:
+		if file == nil {
+			return nil
+		}
+		return c.report(Issue{Line: lineNum, Path: path, Err: ErrConflictingListing,
+			Detail: fmt.Sprintf("listed as a file of size %d but already a directory", file.Size)})
+	case *RegularFile:
+		if file == nil {
+			return c.report(Issue{Line: lineNum, Path: path, Err: ErrConflictingListing,
+				Detail: fmt.Sprintf("listed as a directory but already a file of size %d", existing.Size)})
+		}
+		if file.Size == existing.Size {
+			return nil
+		}
+		return c.report(Issue{Line: lineNum, Path: path, Err: ErrConflictingListing,
+			Detail: fmt.Sprintf("listed with size %d but already %d", file.Size, existing.Size)})
+	}
+
+	if file != nil {
+		if err := c.fs.CreateRegularFile(name, file.Size); err != nil {
+			return fmt.Errorf("line %d: %w", lineNum, err)
+		}
+		return nil
+	}
+	if err := c.fs.CreateDirectory(name); err != nil {
+		return fmt.Errorf("line %d: %w", lineNum, err)
+	}
+	c.seenAt[c.fs.CurrentDir.Children[name].(*Directory)] = lineNum
+	return nil
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// jsonFileSystem is the JSON form of a FileSystem
+type jsonFileSystem struct {
+	DiskSize uint64   `json:"diskSize"`
+	Root     jsonNode `json:"root"`
+}
+
+// jsonNode is the JSON form of a File. Sizes of directories are written out
+// for convenience but ignored when reading, since they follow from the files
+type jsonNode struct {
+	Name     string     `json:"name"`
+	Type     string     `json:"type"`
+	Size     uint64     `json:"size"`
+	Children []jsonNode `json:"children,omitempty"`
+}
+
+func toJSONNode(name string, f File) jsonNode {
+	node := jsonNode{
+		Name: name,
+		Type: f.FileType().String(),
+		Size: fileSize(f),
+	}
+	if dir, ok := f.(*Directory); ok {
+		node.Children = make([]jsonNode, 0, len(dir.Children))
+		for _, childName := range dir.SortedChildNames() {
+			node.Children = append(node.Children, toJSONNode(childName, dir.Children[childName]))
+		}
+	}
+	return node
+}
+
+// MarshalJSON writes the file system as a tree with children in name order
+func (fs *FileSystem) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonFileSystem{
+		DiskSize: fs.DiskSize,
+		Root:     toJSONNode("/", fs.Root),
+	})
+}
+
+// UnmarshalJSON replaces the file system with the tree written by MarshalJSON.
+// The root becomes the current directory
+func (fs *FileSystem) UnmarshalJSON(data []byte) error {
+	var j jsonFileSystem
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	if j.Root.Type != FileTypeDirectory.String() {
+		return fmt.Errorf("root: %w", ErrNotADirectory)
+	}
+	loaded := NewFileSystem(j.DiskSize)
+	if err := loaded.addJSONChildren(j.Root); err != nil {
+		return err
+	}
+	loaded.CurrentDir = loaded.Root
+	*fs = loaded
+	return nil
+}
+
+// addJSONChildren creates the children of node in the current directory
+func (fs *FileSystem) addJSONChildren(node jsonNode) error {
+	for _, child := range node.Children {
+		switch child.Type {
+		case FileTypeRegular.String():
+			if err := fs.CreateRegularFile(child.Name, child.Size); err != nil {
+				return err
+			}
+		case FileTypeDirectory.String():
+			if err := fs.CreateDirectory(child.Name); err != nil {
+				return err
+			}
+			if err := fs.ChangeDirectory(child.Name); err != nil {
+				return err
+			}
+			if err := fs.addJSONChildren(child); err != nil {
+				return err
+			}
+			fs.CurrentDir = fs.CurrentDir.Parent
+		default:
+			return fmt.Errorf("%v: %w: %q", joinPath(fs.CurrentDir.Path(), child.Name), ErrUnknownFileType, child.Type)
+		}
+	}
+	return nil
+}
+
+// Materialize recreates the file system below dir, which must already exist,
+// as real directories and sparse files of the recorded sizes
+func Materialize(dir string, fsys *FileSystem) error {
+	return fsys.Walk(func(p string, f File) error {
+		target := filepath.Join(dir, filepath.FromSlash(p))
+		switch v := f.(type) {
+		case *Directory:
+			if v.IsRoot() {
+				return nil
+			}
+			return os.Mkdir(target, 0755)
+		case *RegularFile:
+			file, err := os.Create(target)
+			if err != nil {
+				return err
+			}
+			// Truncate leaves a hole rather than writing out the zeros
+			if err := file.Truncate(int64(v.Size)); err != nil {
+				file.Close()
+				return err
+			}
+			return file.Close()
+		}
+		return ErrUnknownFileType
+	})
+}
+
+// GenerateTranscript writes a terminal session that lists every directory in
+// fsys, such as os.DirFS of a real directory, so it can be read back with
+// ReadTranscript. Anything that isn't a regular file or directory, like a
+// symlink, is left out
+func GenerateTranscript(w io.Writer, fsys fs.FS) error {
+	bw := bufio.NewWriter(w)
+	if _, err := fmt.Fprintln(bw, "$ cd /"); err != nil {
+		return err
+	}
+	if err := transcribeDir(bw, fsys, "."); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+func transcribeDir(w io.Writer, fsys fs.FS, dir string) error {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(w, "$ ls"); err != nil {
+		return err
+	}
+	var subdirs []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.ContainsAny(name, " \t\r\n") {
+			return fmt.Errorf("%q: %w", path.Join(dir, name), ErrInvalidFileName)
+		}
+		switch {
+		case entry.IsDir():
+			subdirs = append(subdirs, name)
+			_, err = fmt.Fprintf(w, "dir %v\n", name)
+		case entry.Type().IsRegular():
+			info, infoErr := entry.Info()
+			if infoErr != nil {
+				return infoErr
+			}
+			_, err = fmt.Fprintf(w, "%d %v\n", info.Size(), name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for _, name := range subdirs {
+		if _, err := fmt.Fprintf(w, "$ cd %v\n", name); err != nil {
+			return err
+		}
+		if err := transcribeDir(w, fsys, path.Join(dir, name)); err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintln(w, "$ cd .."); err != nil {
+			return err
+		}
+	}
+	return nil
+}
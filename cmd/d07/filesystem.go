@@ -0,0 +1,392 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type FileType int
+
+const (
+	FileTypeRegular FileType = iota
+	FileTypeDirectory
+)
+
+func (t FileType) String() string {
+	switch t {
+	case FileTypeRegular:
+		return "file"
+	case FileTypeDirectory:
+		return "dir"
+	}
+	return fmt.Sprintf("FileType(%d)", int(t))
+}
+
+var (
+	ErrCouldNotChangeIntoDir = errors.New("could not change into directory")
+	ErrDirectoryDoesNotExist = errors.New("directory does not exist")
+	ErrDuplicateFileName     = errors.New("tried to create duplicate file")
+	ErrUnknownFileType       = errors.New("unknown file type")
+	ErrFileDoesNotExist      = errors.New("file does not exist")
+	ErrNotADirectory         = errors.New("not a directory")
+	ErrNotARegularFile       = errors.New("not a regular file")
+	ErrInvalidFileName       = errors.New("invalid file name")
+	ErrCannotRemoveRoot      = errors.New("cannot remove the root directory")
+
+	// ErrSkipDir can be returned by a WalkFunc to skip the rest of a directory
+	ErrSkipDir = errors.New("skip this directory")
+)
+
+type File interface {
+	FileType() FileType
+}
+
+// RegularFile is a file with a recorded size. Change the size with
+// FileSystem.SetFileSize so the cached directory sizes stay correct
+type RegularFile struct {
+	Size uint64
+}
+
+func (f *RegularFile) FileType() FileType {
+	return FileTypeRegular
+}
+
+type Directory struct {
+	Name     string
+	Children map[string]File
+	Parent   *Directory
+
+	size uint64 // Cached cumulative size of everything below the directory
+}
+
+func (f *Directory) FileType() FileType {
+	return FileTypeDirectory
+}
+
+// IsRoot reports whether the directory is the root of its file system
+func (f *Directory) IsRoot() bool {
+	return f.Parent == f
+}
+
+// Path returns the absolute path of the directory
+func (f *Directory) Path() string {
+	if f.IsRoot() {
+		return "/"
+	}
+	return joinPath(f.Parent.Path(), f.Name)
+}
+
+// Size returns the total size of all the regular files below the directory.
+// It is cached and kept up to date by the FileSystem methods
+func (f *Directory) Size() uint64 {
+	return f.size
+}
+
+// grow adds n to the cached size of the directory and all of its ancestors
+func (f *Directory) grow(n uint64) {
+	for d := f; ; d = d.Parent {
+		d.size += n
+		if d.IsRoot() {
+			return
+		}
+	}
+}
+
+// shrink subtracts n from the cached size of the directory and all of its ancestors
+func (f *Directory) shrink(n uint64) {
+	for d := f; ; d = d.Parent {
+		d.size -= n
+		if d.IsRoot() {
+			return
+		}
+	}
+}
+
+// RecomputeSize rebuilds the cached sizes of the directory and everything
+// below it from the regular files, for use after the tree has been changed
+// without going through the FileSystem methods. It returns the new size
+func (f *Directory) RecomputeSize() uint64 {
+	var sum uint64
+	for _, child := range f.Children {
+		switch v := child.(type) {
+		case *Directory:
+			sum += v.RecomputeSize()
+		case *RegularFile:
+			sum += v.Size
+		}
+	}
+	f.size = sum
+	return sum
+}
+
+// SortedChildNames returns the names of the directory's children in order
+func (f *Directory) SortedChildNames() []string {
+	names := make([]string, 0, len(f.Children))
+	for name := range f.Children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// fileSize returns the size of a regular file or the cumulative size of a directory
+func fileSize(f File) uint64 {
+	switch v := f.(type) {
+	case *Directory:
+		return v.Size()
+	case *RegularFile:
+		return v.Size
+	}
+	return 0
+}
+
+// FileStat describes a file found by FileSystem.Stat
+type FileStat struct {
+	Name string
+	Path string
+	Type FileType
+	Size uint64 // Cumulative size for directories
+}
+
+type FileSystem struct {
+	DiskSize   uint64
+	Root       *Directory
+	CurrentDir *Directory
+}
+
+func NewFileSystem(diskSize uint64) FileSystem {
+	root := Directory{
+		Children: map[string]File{},
+	}
+	root.Parent = &root
+	return FileSystem{
+		DiskSize:   diskSize,
+		Root:       &root,
+		CurrentDir: &root,
+	}
+}
+
+// joinPath appends name to the directory path dir
+func joinPath(dir, name string) string {
+	if strings.HasSuffix(dir, "/") {
+		return dir + name
+	}
+	return dir + "/" + name
+}
+
+// resolve follows path from the root if it is absolute or from the current
+// directory otherwise. It returns the file found along with its absolute path
+func (fs *FileSystem) resolve(path string) (File, string, error) {
+	dir := fs.CurrentDir
+	if strings.HasPrefix(path, "/") {
+		dir = fs.Root
+	}
+
+	// Only directories can be walked through, so a regular file has to be
+	// the last segment
+	var file *RegularFile
+	var fileName string
+
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if segment == "" || segment == "." {
+			continue
+		}
+		if file != nil {
+			return nil, "", fmt.Errorf("%v: %w", strings.Join(segments[:i], "/"), ErrNotADirectory)
+		}
+		if segment == ".." {
+			dir = dir.Parent
+			continue
+		}
+		switch v := dir.Children[segment].(type) {
+		case *Directory:
+			dir = v
+		case *RegularFile:
+			file, fileName = v, segment
+		case nil:
+			return nil, "", fmt.Errorf("%v: %w", path, ErrFileDoesNotExist)
+		default:
+			return nil, "", ErrUnknownFileType
+		}
+	}
+	if file != nil {
+		return file, joinPath(dir.Path(), fileName), nil
+	}
+	return dir, dir.Path(), nil
+}
+
+// Lookup returns the file at path, which may be absolute or relative to the
+// current directory and may contain "." and ".." segments
+func (fs *FileSystem) Lookup(path string) (File, error) {
+	f, _, err := fs.resolve(path)
+	return f, err
+}
+
+// Stat describes the file at path
+func (fs *FileSystem) Stat(path string) (FileStat, error) {
+	f, absPath, err := fs.resolve(path)
+	if err != nil {
+		return FileStat{}, err
+	}
+	name := absPath[strings.LastIndex(absPath, "/")+1:]
+	if name == "" {
+		name = "/"
+	}
+	stat := FileStat{
+		Name: name,
+		Path: absPath,
+		Type: f.FileType(),
+	}
+	stat.Size = fileSize(f)
+	return stat, nil
+}
+
+// ChangeDirectory makes the directory at path the current directory
+func (fs *FileSystem) ChangeDirectory(path string) error {
+	f, _, err := fs.resolve(path)
+	if errors.Is(err, ErrFileDoesNotExist) {
+		return fmt.Errorf("%v: %w", path, ErrDirectoryDoesNotExist)
+	}
+	if err != nil {
+		return err
+	}
+	dir, ok := f.(*Directory)
+	if !ok {
+		return fmt.Errorf("%v: %w", path, ErrCouldNotChangeIntoDir)
+	}
+	fs.CurrentDir = dir
+	return nil
+}
+
+func (fs *FileSystem) CreateRegularFile(name string, size uint64) error {
+	return fs.AddDirectoryEntry(name, &RegularFile{
+		Size: size,
+	})
+}
+
+func (fs *FileSystem) CreateDirectory(name string) error {
+	return fs.AddDirectoryEntry(name, &Directory{
+		Name:     name,
+		Children: map[string]File{},
+		Parent:   fs.CurrentDir,
+	})
+}
+
+func (fs *FileSystem) AddDirectoryEntry(name string, entry File) error {
+	if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+		return fmt.Errorf("%q: %w", name, ErrInvalidFileName)
+	}
+	if _, ok := fs.CurrentDir.Children[name]; ok {
+		return fmt.Errorf("%v: %w", joinPath(fs.CurrentDir.Path(), name), ErrDuplicateFileName)
+	}
+	switch v := entry.(type) {
+	case *Directory:
+		v.Name = name
+		v.Parent = fs.CurrentDir
+		fs.CurrentDir.grow(v.RecomputeSize())
+	case *RegularFile:
+		fs.CurrentDir.grow(v.Size)
+	default:
+		return ErrUnknownFileType
+	}
+	fs.CurrentDir.Children[name] = entry
+	return nil
+}
+
+// SetFileSize changes the size of the regular file at path
+func (fs *FileSystem) SetFileSize(path string, size uint64) error {
+	f, absPath, err := fs.resolve(path)
+	if err != nil {
+		return err
+	}
+	file, ok := f.(*RegularFile)
+	if !ok {
+		return fmt.Errorf("%v: %w", path, ErrNotARegularFile)
+	}
+	parent, _, err := fs.resolve(absPath[:strings.LastIndex(absPath, "/")+1])
+	if err != nil {
+		return err
+	}
+	dir := parent.(*Directory)
+	dir.shrink(file.Size)
+	dir.grow(size)
+	file.Size = size
+	return nil
+}
+
+// Remove deletes the file or directory at path along with everything below
+// it. If the current directory is removed, its parent becomes the current
+// directory
+func (fs *FileSystem) Remove(path string) error {
+	f, absPath, err := fs.resolve(path)
+	if err != nil {
+		return err
+	}
+	if absPath == "/" {
+		return ErrCannotRemoveRoot
+	}
+	i := strings.LastIndex(absPath, "/")
+	parentFile, _, err := fs.resolve(absPath[:i+1])
+	if err != nil {
+		return err
+	}
+	parent := parentFile.(*Directory)
+	name := absPath[i+1:]
+
+	if dir, ok := f.(*Directory); ok {
+		for d := fs.CurrentDir; ; d = d.Parent {
+			if d == dir {
+				fs.CurrentDir = parent
+				break
+			}
+			if d.IsRoot() {
+				break
+			}
+		}
+	}
+	parent.shrink(fileSize(f))
+	delete(parent.Children, name)
+	return nil
+}
+
+// WalkFunc is called by Walk for every file with its absolute path
+type WalkFunc func(path string, f File) error
+
+// Walk calls fn for every file in the file system, starting with the root and
+// visiting each directory's children in name order before moving on.
+// Returning ErrSkipDir for a directory skips its contents, and any other
+// error stops the walk and is returned
+func (fs *FileSystem) Walk(fn WalkFunc) error {
+	err := walk("/", fs.Root, fn)
+	if err == ErrSkipDir {
+		return nil
+	}
+	return err
+}
+
+func walk(path string, f File, fn WalkFunc) error {
+	if err := fn(path, f); err != nil {
+		return err
+	}
+	dir, ok := f.(*Directory)
+	if !ok {
+		return nil
+	}
+	for _, name := range dir.SortedChildNames() {
+		err := walk(joinPath(path, name), dir.Children[name], fn)
+		if err == ErrSkipDir {
+			if _, isDir := dir.Children[name].(*Directory); isDir {
+				continue
+			}
+			// Skipping from a regular file skips the rest of its directory
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"time"
+)
+
+// TranscriptFS exposes a reconstructed FileSystem through io/fs so standard
+// tooling such as fs.WalkDir and fs.Glob can be used on it. Regular files read
+// as zeros up to their recorded size and directories report their cumulative
+// size
+type TranscriptFS struct {
+	fsys *FileSystem
+}
+
+var (
+	_ fs.FS        = (*TranscriptFS)(nil)
+	_ fs.ReadDirFS = (*TranscriptFS)(nil)
+	_ fs.StatFS    = (*TranscriptFS)(nil)
+)
+
+func NewTranscriptFS(fsys *FileSystem) *TranscriptFS {
+	return &TranscriptFS{fsys: fsys}
+}
+
+// lookup finds the file for an io/fs path, where "." is the root
+func (t *TranscriptFS) lookup(op, name string) (File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	f, err := t.fsys.Lookup("/" + name)
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return f, nil
+}
+
+func (t *TranscriptFS) Open(name string) (fs.File, error) {
+	f, err := t.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	info := fileInfo{name: path.Base(name), file: f}
+	if dir, ok := f.(*Directory); ok {
+		return &openDir{info: info, dir: dir}, nil
+	}
+	return &openFile{info: info}, nil
+}
+
+func (t *TranscriptFS) Stat(name string) (fs.FileInfo, error) {
+	f, err := t.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return fileInfo{name: path.Base(name), file: f}, nil
+}
+
+func (t *TranscriptFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	f, err := t.lookup("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	dir, ok := f.(*Directory)
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: ErrNotADirectory}
+	}
+	return dirEntries(dir), nil
+}
+
+// dirEntries lists a directory's children in name order
+func dirEntries(dir *Directory) []fs.DirEntry {
+	names := dir.SortedChildNames()
+	entries := make([]fs.DirEntry, len(names))
+	for i, name := range names {
+		entries[i] = fileInfo{name: name, file: dir.Children[name]}
+	}
+	return entries
+}
+
+// fileInfo is a synthetic fs.FileInfo and fs.DirEntry for a File
+type fileInfo struct {
+	name string
+	file File
+}
+
+func (i fileInfo) Name() string {
+	return i.name
+}
+
+func (i fileInfo) Size() int64 {
+	return int64(fileSize(i.file))
+}
+
+func (i fileInfo) Mode() fs.FileMode {
+	if i.IsDir() {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+func (i fileInfo) ModTime() time.Time {
+	return time.Time{}
+}
+
+func (i fileInfo) IsDir() bool {
+	return i.file.FileType() == FileTypeDirectory
+}
+
+func (i fileInfo) Sys() any {
+	return i.file
+}
+
+func (i fileInfo) Type() fs.FileMode {
+	return i.Mode().Type()
+}
+
+func (i fileInfo) Info() (fs.FileInfo, error) {
+	return i, nil
+}
+
+// openFile is an open regular file whose contents are all zeros
+type openFile struct {
+	info   fileInfo
+	offset int64
+}
+
+func (f *openFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *openFile) Read(b []byte) (int, error) {
+	remaining := f.info.Size() - f.offset
+	if remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(b)) > remaining {
+		b = b[:remaining]
+	}
+	for i := range b {
+		b[i] = 0
+	}
+	f.offset += int64(len(b))
+	return len(b), nil
+}
+
+func (f *openFile) Close() error {
+	return nil
+}
+
+// openDir is an open directory that can be listed in batches
+type openDir struct {
+	info    fileInfo
+	dir     *Directory
+	entries []fs.DirEntry // Filled on the first call to ReadDir
+	offset  int
+}
+
+func (d *openDir) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *openDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: fs.ErrInvalid}
+}
+
+func (d *openDir) Close() error {
+	return nil
+}
+
+func (d *openDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if d.entries == nil {
+		d.entries = dirEntries(d.dir)
+	}
+	remaining := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return remaining, nil
+	}
+	if len(remaining) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(remaining) {
+		n = len(remaining)
+	}
+	d.offset += n
+	return remaining[:n], nil
+}
+
+// printGlob lists the files matching pattern along with their sizes
+func printGlob(w io.Writer, fsys *FileSystem, pattern string) error {
+	tfs := NewTranscriptFS(fsys)
+	matches, err := fs.Glob(tfs, pattern)
+	if err != nil {
+		return err
+	}
+	for _, match := range matches {
+		info, err := fs.Stat(tfs, match)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "%v\t%v\n", info.Size(), match); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,118 +1,65 @@
 package main
 
 import (
-	"bufio"
-	"errors"
+	"bytes"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"regexp"
-	"strconv"
 )
 
-type FileType int
-
-const (
-	FileTypeRegular FileType = iota
-	FileTypeDirectory
-)
-
-type File interface {
-	FileType() FileType
-}
-
-type RegularFile struct {
-	Size uint64
-}
-
-func (f *RegularFile) FileType() FileType {
-	return FileTypeRegular
-}
-
-type Directory struct {
-	Children map[string]File
-	Parent   *Directory
-}
-
-func (f *Directory) FileType() FileType {
-	return FileTypeDirectory
-}
-
-type FileSystem struct {
-	DiskSize   uint64
-	Root       *Directory
-	CurrentDir *Directory
-}
-
-func NewFileSystem(diskSize uint64) FileSystem {
-	root := Directory{
-		Children: map[string]File{},
-	}
-	root.Parent = &root
-	return FileSystem{
-		DiskSize:   diskSize,
-		Root:       &root,
-		CurrentDir: &root,
-	}
-}
-
-func (fs *FileSystem) ChangeDirectory(dirName string) {
-	if dirName == "/" {
-		fs.CurrentDir = fs.Root
-		return
-	}
-	if dirName == ".." {
-		fs.CurrentDir = fs.CurrentDir.Parent
-		return
-	}
-	v, ok := fs.CurrentDir.Children[dirName]
-	if !ok && dirName != ".." {
-		log.Fatal(ErrDirectoryDoesNotExist)
-	}
-	dir, ok := v.(*Directory)
-	if !ok {
-		log.Fatal(ErrCouldNotChangeIntoDir)
-	}
-	fs.CurrentDir = dir
-}
-
-func (fs *FileSystem) CreateRegularFile(name string, size uint64) {
-	fs.AddDirectoryEntry(name, &RegularFile{
-		Size: size,
-	})
-}
-func (fs *FileSystem) CreateDirectory(name string) {
-	fs.AddDirectoryEntry(name, &Directory{
-		Children: map[string]File{},
-		Parent:   fs.CurrentDir,
-	})
-}
-
-func (fs *FileSystem) AddDirectoryEntry(name string, entry File) {
-	if _, ok := fs.CurrentDir.Children[name]; ok {
-		log.Fatal(ErrDuplicateFileName)
-	}
-	fs.CurrentDir.Children[name] = entry
-
-}
-
 var (
 	CdRgx   = regexp.MustCompile(`^\$ +cd +([^ ]+)$`)
 	LsRgx   = regexp.MustCompile(`^\$ +ls$`)
 	DirRgx  = regexp.MustCompile(`^dir +([^ ]+)$`)
 	FileRgx = regexp.MustCompile(`^([0-9]+) +([^ ]+)$`)
+)
 
-	ErrCouldNotChangeIntoDir = errors.New("could not change into directory")
-	ErrDirectoryDoesNotExist = errors.New("directory does not exist")
-	ErrDuplicateFileName     = errors.New("tried to create duplicate file")
-	ErrUnknownFileType       = errors.New("unknown file type")
+var (
+	globPattern string
+	showTree    bool
+	showDu      bool
+	renderOpts  RenderOptions
+	queryText   string
+	checkOnly   bool
+	strict      bool
+	fromJSON    bool
+	fromDir     bool
+	toJSON      bool
+	toScript    bool
+	materialize string
+	capacity    uint64
+	target      uint64
+	showPlan    bool
+	interactive bool
 )
 
 func main() {
 	// Parse flags
 	{
 		partBFlag := flag.Bool("b", false, "To switch to part b")
+		flag.StringVar(&globPattern, "glob", "", "List the files matching this pattern (e.g. \"*/*.log\") with their sizes")
+		flag.BoolVar(&showTree, "tree", false, "Print the file system like tree with cumulative directory sizes")
+		flag.BoolVar(&showDu, "du", false, "Print directory sizes like du -h")
+		flag.StringVar(&renderOpts.SortBy, "sort", SortByName, "Order for -tree and -du: name or size")
+		flag.IntVar(&renderOpts.MaxDepth, "max-depth", -1, "Deepest level to show with -tree and -du, negative for no limit")
+		flag.Uint64Var(&renderOpts.MinSize, "min-size", 0, "Hide files and directories smaller than this many bytes with -tree and -du")
+		flag.BoolVar(&renderOpts.Human, "human", false, "Show human-readable sizes with -tree")
+		flag.StringVar(&queryText, "query", "", "List the directories matching a query such as 'size > 100000 and name ~ \"log\"'")
+		flag.BoolVar(&checkOnly, "check", false, "Report every inconsistency in the transcript and exit")
+		flag.BoolVar(&strict, "strict", false, "Stop at the first inconsistency in the transcript instead of warning about it")
+		flag.BoolVar(&fromJSON, "from-json", false, "Read the file system from JSON written by -to-json instead of a transcript")
+		flag.BoolVar(&fromDir, "from-dir", false, "Read the file system from a real directory instead of a transcript")
+		flag.BoolVar(&toJSON, "to-json", false, "Print the file system as JSON")
+		flag.BoolVar(&toScript, "transcript", false, "Print a transcript that lists the whole file system")
+		flag.StringVar(&materialize, "materialize", "", "Create the file system as sparse files in a new temporary directory inside this one")
+		flag.Uint64Var(&capacity, "capacity", 70000000, "Total size of the disk")
+		flag.Uint64Var(&target, "target", 30000000, "Free space needed for the update")
+		flag.BoolVar(&showPlan, "plan", false, "Find the directories to delete that free the target space while deleting the fewest bytes")
+		flag.BoolVar(&interactive, "shell", false, "Explore the file system with cd, ls, du, find, rm and what-if commands")
 		flag.Parse()
 		if partBFlag != nil && *partBFlag {
 		}
@@ -124,39 +71,134 @@ func main() {
 		log.Fatal("Expected 1 argument containing file name!")
 	}
 
-	// Open file
-	file, err := os.Open(flag.Args()[0])
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer file.Close()
-
-	fs := NewFileSystem(70000000)
+	fs := NewFileSystem(capacity)
+	if fromDir {
+		// Go through a transcript so a real directory gets the same checks
+		var transcript bytes.Buffer
+		if err := GenerateTranscript(&transcript, os.DirFS(flag.Args()[0])); err != nil {
+			log.Fatal(err)
+		}
+		if err := ReadTranscript(&transcript, &fs); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		// Open file
+		file, err := os.Open(flag.Args()[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer file.Close()
 
-	// Iterate over lines and build up file system
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if matches := CdRgx.FindStringSubmatch(line); len(matches) == 2 {
-			fs.ChangeDirectory(matches[1])
-		} else if matches := LsRgx.FindStringSubmatch(line); len(matches) == 1 {
-			// No-op
-		} else if matches := FileRgx.FindStringSubmatch(line); len(matches) == 3 {
-			size, err := strconv.ParseUint(matches[1], 10, 64)
+		if fromJSON {
+			data, err := io.ReadAll(file)
 			if err != nil {
-				log.Fatal("could not parse filesize: %v\n", matches[1])
+				log.Fatal(err)
+			}
+			if err := json.Unmarshal(data, &fs); err != nil {
+				log.Fatal(err)
 			}
-			fs.CreateRegularFile(matches[2], size)
-		} else if matches := DirRgx.FindStringSubmatch(line); len(matches) == 2 {
+		} else {
+			mode := TranscriptLenient
+			if strict && !checkOnly {
+				mode = TranscriptStrict
+			}
+			issues, err := CheckTranscript(file, &fs, mode)
 			if err != nil {
-				log.Fatal("could not parse filesize: %v\n", matches[1])
+				log.Fatal(err)
+			}
+			if checkOnly {
+				for _, issue := range issues {
+					fmt.Println(issue)
+				}
+				if len(issues) > 0 {
+					os.Exit(1)
+				}
+				return
+			}
+			for _, issue := range issues {
+				log.Print("warning: ", issue)
 			}
-			fs.CreateDirectory(matches[1])
 		}
+	}
+
+	if toJSON {
+		data, err := json.MarshalIndent(&fs, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(data))
+		return
+	}
+	if toScript {
+		if err := GenerateTranscript(os.Stdout, NewTranscriptFS(&fs)); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if materialize != "" {
+		dir, err := os.MkdirTemp(materialize, "d07-")
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := Materialize(dir, &fs); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(dir)
+		return
+	}
+
+	if interactive {
+		fs.CurrentDir = fs.Root
+		shell := Shell{FS: &fs, Target: target}
+		if err := shell.Run(os.Stdin, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if showTree {
+		if err := RenderTree(os.Stdout, fs.Root, renderOpts); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if showDu {
+		renderOpts.Human = true
+		if err := RenderDu(os.Stdout, fs.Root, renderOpts); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
+	if queryText != "" {
+		query, err := CompileQuery(queryText)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, info := range query.Run(BuildDirIndex(&fs)) {
+			fmt.Printf("%v\t%v\n", info.Size, info.Path)
+		}
+		return
 	}
-	if err := scanner.Err(); err != nil {
-		log.Fatal(err)
+
+	if showPlan {
+		plan, err := PlanDeletion(&fs, target)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("%v of %v used, %v free, %v needed\n", plan.Used, fs.DiskSize, plan.Free, plan.Needed)
+		for _, entry := range plan.Dirs {
+			fmt.Printf("rm -r %v\t# %v\n", entry.Path, entry.Size)
+		}
+		fmt.Printf("Reclaims %v, leaving %v free\n", plan.Reclaimed, plan.FreeAfter())
+		return
+	}
+
+	if globPattern != "" {
+		if err := printGlob(os.Stdout, &fs, globPattern); err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
 
 	fmt.Println("------------------")
@@ -167,9 +209,17 @@ func main() {
 	fmt.Printf("Sum of directories under maximum %v is: %v\n", maxSize, totalSumUnderMax)
 
 	// Part B
-	dirName, dirSize := PartB(&fs, totalUsed)
-	fmt.Printf("Directory to remove is %v with a size of %v\n", dirName, dirSize)
+	dirPath, dirSize := PartB(&fs, totalUsed, target)
+	fmt.Printf("Directory to remove is %v with a size of %v\n", dirPath, dirSize)
+
+}
 
+// ReadTranscript iterates over the lines of a terminal session and builds up
+// the file system from them. Inconsistencies are worked around as in
+// TranscriptLenient, use CheckTranscript to find out about them
+func ReadTranscript(r io.Reader, fs *FileSystem) error {
+	_, err := CheckTranscript(r, fs, TranscriptLenient)
+	return err
 }
 
 // PartA finds sum of the sizes of all directories under maxSize
@@ -179,35 +229,38 @@ func PartA(fs *FileSystem, maxSize uint64) (totalSumUnderMax uint64, totalUsed u
 	return totalSumUnderMax, totalUsed
 }
 
-// PartB finds smallest directory to remove to get the amount of remaining space
-func PartB(fs *FileSystem, totalUsed uint64) (dirName string, dirSize uint64) {
-	unused := fs.DiskSize - totalUsed
-	neededForUpdate := 30000000 - unused
+// PartB finds smallest directory to remove to leave target bytes free and
+// returns its full path, or nothing if there is already enough space
+func PartB(fs *FileSystem, totalUsed, target uint64) (dirPath string, dirSize uint64) {
+	var unused uint64
+	if totalUsed < fs.DiskSize {
+		unused = fs.DiskSize - totalUsed
+	}
+	if unused >= target {
+		return "", 0
+	}
+	neededForUpdate := target - unused
 	flatDirSizes := map[string]uint64{}
 	AddToFlatMapIfOverMin("/", fs.Root, neededForUpdate, flatDirSizes)
 
 	// Iterate over directories with size greater than min
 	// to find the smallest one
-	for currDirName, currSize := range flatDirSizes {
-		if dirSize == 0 || currSize < dirSize {
-			dirName = currDirName
+	for currDirPath, currSize := range flatDirSizes {
+		if dirSize == 0 || currSize < dirSize || (currSize == dirSize && currDirPath < dirPath) {
+			dirPath = currDirPath
 			dirSize = currSize
 		}
 	}
-	return dirName, dirSize
+	return dirPath, dirSize
 }
 
+// AddSizeToSumIfUnderMax adds the size of every directory under maxSize to sum
+// and returns the size of dir
 func AddSizeToSumIfUnderMax(dir *Directory, maxSize uint64, sum *uint64) uint64 {
-	var localSum uint64
+	localSum := dir.Size()
 	for _, childFile := range dir.Children {
-		switch v := childFile.(type) {
-		case *Directory:
-			dirSum := AddSizeToSumIfUnderMax(v, maxSize, sum)
-			localSum += dirSum
-		case *RegularFile:
-			localSum += v.Size
-		default:
-			log.Fatal(ErrUnknownFileType)
+		if v, ok := childFile.(*Directory); ok {
+			AddSizeToSumIfUnderMax(v, maxSize, sum)
 		}
 	}
 	if localSum < maxSize {
@@ -216,21 +269,17 @@ func AddSizeToSumIfUnderMax(dir *Directory, maxSize uint64, sum *uint64) uint64
 	return localSum
 }
 
-func AddToFlatMapIfOverMin(dirName string, dir *Directory, minSize uint64, flatMap map[string]uint64) uint64 {
-	var localSum uint64
+// AddToFlatMapIfOverMin records the size of every directory over minSize in
+// flatMap, keyed by full path so directories with the same name don't collide
+func AddToFlatMapIfOverMin(dirPath string, dir *Directory, minSize uint64, flatMap map[string]uint64) uint64 {
+	localSum := dir.Size()
 	for childName, childFile := range dir.Children {
-		switch v := childFile.(type) {
-		case *Directory:
-			dirSum := AddToFlatMapIfOverMin(childName, v, minSize, flatMap)
-			localSum += dirSum
-		case *RegularFile:
-			localSum += v.Size
-		default:
-			log.Fatal(ErrUnknownFileType)
+		if v, ok := childFile.(*Directory); ok {
+			AddToFlatMapIfOverMin(joinPath(dirPath, childName), v, minSize, flatMap)
 		}
 	}
 	if localSum > minSize {
-		flatMap[dirName] = localSum
+		flatMap[dirPath] = localSum
 	}
 	return localSum
 }
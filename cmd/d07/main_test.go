@@ -0,0 +1,1007 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+const exampleTranscript = `$ cd /
+$ ls
+dir a
+14848514 b.txt
+8504156 c.dat
+dir d
+$ cd a
+$ ls
+dir e
+29116 f
+2557 g
+62596 h.lst
+$ cd e
+$ ls
+584 i
+$ cd ..
+$ cd ..
+$ cd d
+$ ls
+4060174 j
+8033020 d.log
+5626152 d.ext
+7214296 k
+`
+
+// loadExample builds the file system from the puzzle example
+func loadExample(t testing.TB) FileSystem {
+	fs := NewFileSystem(70000000)
+	if err := ReadTranscript(strings.NewReader(exampleTranscript), &fs); err != nil {
+		t.Fatal(err)
+	}
+	return fs
+}
+
+func TestExample(t *testing.T) {
+	fs := loadExample(t)
+	sum, used := PartA(&fs, 100000)
+	if sum != 95437 || used != 48381165 {
+		t.Errorf("Expected 95437 and 48381165 but got %v and %v", sum, used)
+	}
+	name, size := PartB(&fs, used, 30000000)
+	if name != "/d" || size != 24933642 {
+		t.Errorf("Expected /d with 24933642 but got %v with %v", name, size)
+	}
+}
+
+func TestPathResolution(t *testing.T) {
+	fs := loadExample(t)
+	if err := fs.ChangeDirectory("/a/e"); err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]string{
+		"/":             "/",
+		".":             "/a/e",
+		"./i":           "/a/e/i",
+		"..":            "/a",
+		"../..":         "/",
+		"../../..":      "/",
+		"../h.lst":      "/a/h.lst",
+		"../../d/./k":   "/d/k",
+		"/a//e/../f":    "/a/f",
+		"/d/":           "/d",
+		"../e/../../d/": "/d",
+	}
+	for path, expected := range cases {
+		stat, err := fs.Stat(path)
+		if err != nil {
+			t.Errorf("Could not stat %v: %v", path, err)
+			continue
+		}
+		if stat.Path != expected {
+			t.Errorf("Expected %v to resolve to %v but got %v", path, expected, stat.Path)
+		}
+	}
+
+	failures := map[string]error{
+		"nope":      ErrFileDoesNotExist,
+		"/a/nope/i": ErrFileDoesNotExist,
+		"/b.txt/x":  ErrNotADirectory,
+		"/d/k/..":   ErrNotADirectory,
+	}
+	for path, expected := range failures {
+		_, err := fs.Lookup(path)
+		if !errors.Is(err, expected) {
+			t.Errorf("Expected %v for %v but got %v", expected, path, err)
+		}
+	}
+}
+
+func TestStat(t *testing.T) {
+	fs := loadExample(t)
+	cases := []FileStat{
+		{Name: "/", Path: "/", Type: FileTypeDirectory, Size: 48381165},
+		{Name: "a", Path: "/a", Type: FileTypeDirectory, Size: 94853},
+		{Name: "e", Path: "/a/e", Type: FileTypeDirectory, Size: 584},
+		{Name: "d.log", Path: "/d/d.log", Type: FileTypeRegular, Size: 8033020},
+	}
+	for _, expected := range cases {
+		stat, err := fs.Stat(expected.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stat != expected {
+			t.Errorf("Expected %+v but got %+v", expected, stat)
+		}
+	}
+}
+
+func TestChangeDirectory(t *testing.T) {
+	fs := loadExample(t)
+	if fs.CurrentDir.Path() != "/d" {
+		t.Errorf("Expected the transcript to end in /d but in %v", fs.CurrentDir.Path())
+	}
+	if err := fs.ChangeDirectory("../a/e"); err != nil {
+		t.Fatal(err)
+	}
+	if fs.CurrentDir.Path() != "/a/e" {
+		t.Errorf("Expected to be in /a/e but in %v", fs.CurrentDir.Path())
+	}
+	if err := fs.ChangeDirectory("i"); !errors.Is(err, ErrCouldNotChangeIntoDir) {
+		t.Errorf("Expected %v but got %v", ErrCouldNotChangeIntoDir, err)
+	}
+	if err := fs.ChangeDirectory("nope"); !errors.Is(err, ErrDirectoryDoesNotExist) {
+		t.Errorf("Expected %v but got %v", ErrDirectoryDoesNotExist, err)
+	}
+	if fs.CurrentDir.Path() != "/a/e" {
+		t.Errorf("Failed changes should leave us in /a/e but in %v", fs.CurrentDir.Path())
+	}
+	if err := fs.CreateDirectory("i"); !errors.Is(err, ErrDuplicateFileName) {
+		t.Errorf("Expected %v but got %v", ErrDuplicateFileName, err)
+	}
+	if err := fs.CreateDirectory("x/y"); !errors.Is(err, ErrInvalidFileName) {
+		t.Errorf("Expected %v but got %v", ErrInvalidFileName, err)
+	}
+}
+
+func TestWalk(t *testing.T) {
+	fs := loadExample(t)
+	var visited []string
+	err := fs.Walk(func(path string, f File) error {
+		visited = append(visited, path)
+		if path == "/a/e" {
+			return ErrSkipDir
+		}
+		if path == "/d/d.log" {
+			return ErrSkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "/ /a /a/e /a/f /a/g /a/h.lst /b.txt /c.dat /d /d/d.ext /d/d.log"
+	if strings.Join(visited, " ") != expected {
+		t.Errorf("Expected walk order %v but got %v", expected, strings.Join(visited, " "))
+	}
+
+	stop := errors.New("stop")
+	var count int
+	err = fs.Walk(func(path string, f File) error {
+		count++
+		if count == 3 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop || count != 3 {
+		t.Errorf("Expected the walk to stop after 3 files with %v but got %v after %v", stop, err, count)
+	}
+}
+
+func TestTranscriptFS(t *testing.T) {
+	fsys := loadExample(t)
+	tfs := NewTranscriptFS(&fsys)
+	if err := fstest.TestFS(tfs, "a/e/i", "a/f", "b.txt", "d/k"); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := fs.Stat(tfs, "d/d.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 8033020 || info.IsDir() {
+		t.Errorf("Unexpected info for d/d.log: %v %v", info.Size(), info.IsDir())
+	}
+	if _, err := fs.Stat(tfs, "../a"); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("Expected %v but got %v", fs.ErrInvalid, err)
+	}
+	if _, err := tfs.Open("a/nope"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected %v but got %v", fs.ErrNotExist, err)
+	}
+
+	matches, err := fs.Glob(tfs, "*/*.*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(matches, " ") != "a/h.lst d/d.ext d/d.log" {
+		t.Errorf("Unexpected glob matches: %v", matches)
+	}
+}
+
+func TestWalkDirMatchesPartA(t *testing.T) {
+	fsys := loadExample(t)
+	expected, _ := PartA(&fsys, 100000)
+
+	// Part A again, but with standard tooling in place of AddSizeToSumIfUnderMax
+	var sum uint64
+	err := fs.WalkDir(NewTranscriptFS(&fsys), ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.Size() < 100000 {
+			sum += uint64(info.Size())
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum != expected {
+		t.Errorf("Expected %v but got %v", expected, sum)
+	}
+}
+
+func TestRenderTree(t *testing.T) {
+	fsys := loadExample(t)
+	var b strings.Builder
+	opts := RenderOptions{SortBy: SortBySize, MaxDepth: 2, MinSize: 10000}
+	if err := RenderTree(&b, fsys.Root, opts); err != nil {
+		t.Fatal(err)
+	}
+	expected := `/ (48381165)
+├── d (24933642)
+│   ├── d.log (8033020)
+│   ├── k (7214296)
+│   ├── d.ext (5626152)
+│   └── j (4060174)
+├── b.txt (14848514)
+├── c.dat (8504156)
+└── a (94853)
+    ├── h.lst (62596)
+    └── f (29116)
+`
+	if b.String() != expected {
+		t.Errorf("Expected:\n%v\nbut got:\n%v", expected, b.String())
+	}
+
+	if err := RenderTree(&b, fsys.Root, RenderOptions{SortBy: "age"}); !errors.Is(err, ErrUnknownSortOrder) {
+		t.Errorf("Expected %v but got %v", ErrUnknownSortOrder, err)
+	}
+}
+
+func TestRenderDu(t *testing.T) {
+	fsys := loadExample(t)
+	var b strings.Builder
+	if err := RenderDu(&b, fsys.Root, RenderOptions{MaxDepth: -1, Human: true}); err != nil {
+		t.Fatal(err)
+	}
+	expected := "584B\t/a/e\n93K\t/a\n24M\t/d\n46M\t/\n"
+	if b.String() != expected {
+		t.Errorf("Expected:\n%v\nbut got:\n%v", expected, b.String())
+	}
+
+	b.Reset()
+	if err := RenderDu(&b, fsys.Root, RenderOptions{MaxDepth: 1, MinSize: 100000}); err != nil {
+		t.Fatal(err)
+	}
+	expected = "24933642\t/d\n48381165\t/\n"
+	if b.String() != expected {
+		t.Errorf("Expected:\n%v\nbut got:\n%v", expected, b.String())
+	}
+}
+
+func TestHumanSize(t *testing.T) {
+	cases := map[uint64]string{
+		0:          "0B",
+		1023:       "1023B",
+		1024:       "1.0K",
+		1536:       "1.5K",
+		94853:      "93K",
+		24933642:   "24M",
+		8504156:    "8.1M",
+		1 << 40:    "1.0T",
+		^uint64(0): "16E",
+	}
+	for size, expected := range cases {
+		if got := HumanSize(size); got != expected {
+			t.Errorf("Expected %v for %v but got %v", expected, size, got)
+		}
+	}
+}
+
+// duplicateNamesTranscript has two directories named "a", where the one
+// visited second would hide the better candidate if keyed by name alone
+const duplicateNamesTranscript = `$ cd /
+$ ls
+dir a
+dir x
+19000000 big
+$ cd a
+$ ls
+12000000 small
+$ cd ..
+$ cd x
+$ ls
+dir a
+$ cd a
+$ ls
+20000000 bigger
+`
+
+func TestPartBWithDuplicateNames(t *testing.T) {
+	fs := NewFileSystem(70000000)
+	if err := ReadTranscript(strings.NewReader(duplicateNamesTranscript), &fs); err != nil {
+		t.Fatal(err)
+	}
+	_, used := PartA(&fs, 100000)
+	// 51000000 used leaves 19000000 free, so 11000000 is needed. Both
+	// directories named "a" are big enough but only /a is the smallest
+	path, size := PartB(&fs, used, 30000000)
+	if path != "/a" || size != 12000000 {
+		t.Errorf("Expected /a with 12000000 but got %v with %v", path, size)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	fs := loadExample(t)
+	index := BuildDirIndex(&fs)
+	cases := map[string]string{
+		`size > 100000`:                              "/ /d",
+		`size > 100000 and depth <= 0`:               "/",
+		`depth >= 1 and not (name = "d")`:            "/a /a/e",
+		`name ~ "^[ae]$" or path = "/d"`:             "/a /a/e /d",
+		`NAME !~ "e" AND size < 1000000`:             "/a",
+		`path ~ "^/a/"`:                              "/a/e",
+		`size = 584 or size = 94853 and depth = 99`:  "/a/e",
+		`(size = 584 or size = 94853) and depth > 0`: "/a /a/e",
+		`name = "a b"`:                               "",
+	}
+	for text, expected := range cases {
+		q, err := CompileQuery(text)
+		if err != nil {
+			t.Errorf("Could not compile %v: %v", text, err)
+			continue
+		}
+		var paths []string
+		for _, info := range q.Run(index) {
+			paths = append(paths, info.Path)
+		}
+		if strings.Join(paths, " ") != expected {
+			t.Errorf("Expected %q for %v but got %q", expected, text, strings.Join(paths, " "))
+		}
+	}
+
+	evilQueries := []string{
+		``,
+		`size`,
+		`size >`,
+		`size > big`,
+		`size ~ "1"`,
+		`name > "a"`,
+		`name = a`,
+		`owner = "me"`,
+		`size > 1 and`,
+		`(size > 1`,
+		`size > 1)`,
+		`name ~ "("`,
+		`size > 1 & depth < 2`,
+	}
+	for _, text := range evilQueries {
+		if _, err := CompileQuery(text); !errors.Is(err, ErrInvalidQuery) {
+			t.Errorf("Expected %v for %q but got %v", ErrInvalidQuery, text, err)
+		}
+	}
+}
+
+// uncachedSize adds up the regular files below dir without the cache
+func uncachedSize(dir *Directory) uint64 {
+	var sum uint64
+	for _, child := range dir.Children {
+		switch v := child.(type) {
+		case *Directory:
+			sum += uncachedSize(v)
+		case *RegularFile:
+			sum += v.Size
+		}
+	}
+	return sum
+}
+
+// checkCachedSizes reports every directory whose cached size is stale
+func checkCachedSizes(t *testing.T, fs *FileSystem) {
+	t.Helper()
+	err := fs.Walk(func(path string, f File) error {
+		if dir, ok := f.(*Directory); ok && dir.Size() != uncachedSize(dir) {
+			t.Errorf("Cached size of %v is %v but should be %v", path, dir.Size(), uncachedSize(dir))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCachedSizes(t *testing.T) {
+	fs := loadExample(t)
+	checkCachedSizes(t, &fs)
+
+	if err := fs.SetFileSize("/a/e/i", 1000); err != nil {
+		t.Fatal(err)
+	}
+	checkCachedSizes(t, &fs)
+	if fs.Root.Size() != 48381165+416 {
+		t.Errorf("Expected the root to grow by 416 but it is %v", fs.Root.Size())
+	}
+	if err := fs.SetFileSize("/a/e", 1); !errors.Is(err, ErrNotARegularFile) {
+		t.Errorf("Expected %v but got %v", ErrNotARegularFile, err)
+	}
+
+	if err := fs.ChangeDirectory("/a/e"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Remove("/a"); err != nil {
+		t.Fatal(err)
+	}
+	checkCachedSizes(t, &fs)
+	if fs.CurrentDir != fs.Root {
+		t.Errorf("Expected removing /a to move us to / but in %v", fs.CurrentDir.Path())
+	}
+	if _, err := fs.Lookup("/a"); !errors.Is(err, ErrFileDoesNotExist) {
+		t.Errorf("Expected %v but got %v", ErrFileDoesNotExist, err)
+	}
+	if err := fs.Remove("d/k"); err != nil {
+		t.Fatal(err)
+	}
+	checkCachedSizes(t, &fs)
+	if fs.Root.Size() != 14848514+8504156+24933642-7214296 {
+		t.Errorf("Unexpected root size %v", fs.Root.Size())
+	}
+	if err := fs.Remove("/"); !errors.Is(err, ErrCannotRemoveRoot) {
+		t.Errorf("Expected %v but got %v", ErrCannotRemoveRoot, err)
+	}
+
+	// Grafting a prebuilt subtree counts everything below it
+	sub := NewFileSystem(0)
+	if err := sub.CreateRegularFile("x", 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.CreateDirectory("new"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.ChangeDirectory("new"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.AddDirectoryEntry("sub", sub.Root); err != nil {
+		t.Fatal(err)
+	}
+	checkCachedSizes(t, &fs)
+	if stat, err := fs.Stat("/new/sub/x"); err != nil || stat.Size != 10 {
+		t.Errorf("Expected /new/sub/x with 10 but got %v, %v", stat, err)
+	}
+}
+
+// generateTranscript writes a terminal session that lists a tree of dirs
+// directories, each holding filesPerDir files and up to fanout
+// subdirectories
+func generateTranscript(w io.Writer, dirs, filesPerDir, fanout int) {
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+	var list func(n int)
+	list = func(n int) {
+		fmt.Fprintln(bw, "$ ls")
+		var children []int
+		for c := n*fanout + 1; c <= n*fanout+fanout && c < dirs; c++ {
+			children = append(children, c)
+			fmt.Fprintf(bw, "dir d%d\n", c)
+		}
+		for f := 0; f < filesPerDir; f++ {
+			fmt.Fprintf(bw, "%d f%d\n", (n*31+f*17)%100000+1, f)
+		}
+		for _, c := range children {
+			fmt.Fprintf(bw, "$ cd d%d\n", c)
+			list(c)
+			fmt.Fprintln(bw, "$ cd ..")
+		}
+	}
+	fmt.Fprintln(bw, "$ cd /")
+	list(0)
+}
+
+// loadGenerated builds a file system from a generated transcript
+func loadGenerated(b *testing.B, dirs, filesPerDir int) (FileSystem, string) {
+	var sb strings.Builder
+	generateTranscript(&sb, dirs, filesPerDir, 8)
+	fs := NewFileSystem(math.MaxUint64)
+	if err := ReadTranscript(strings.NewReader(sb.String()), &fs); err != nil {
+		b.Fatal(err)
+	}
+	return fs, sb.String()
+}
+
+var benchmarkSizes = []struct {
+	dirs, filesPerDir int
+}{
+	{1000, 10},
+	{10000, 100},
+	{100000, 20},
+}
+
+func BenchmarkReadTranscript(b *testing.B) {
+	for _, size := range benchmarkSizes {
+		b.Run(fmt.Sprintf("files=%d", size.dirs*size.filesPerDir), func(b *testing.B) {
+			_, transcript := loadGenerated(b, size.dirs, size.filesPerDir)
+			b.SetBytes(int64(len(transcript)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				fs := NewFileSystem(math.MaxUint64)
+				if err := ReadTranscript(strings.NewReader(transcript), &fs); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkDirSizes(b *testing.B) {
+	for _, size := range benchmarkSizes {
+		fs, _ := loadGenerated(b, size.dirs, size.filesPerDir)
+		var dirs []*Directory
+		deepest := fs.Root
+		err := fs.Walk(func(path string, f File) error {
+			if dir, ok := f.(*Directory); ok {
+				dirs = append(dirs, dir)
+				if strings.Count(path, "/") > strings.Count(deepest.Path(), "/") {
+					deepest = dir
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		name := fmt.Sprintf("files=%d", size.dirs*size.filesPerDir)
+		b.Run(name+"/cached", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for _, dir := range dirs {
+					dir.Size()
+				}
+			}
+		})
+		b.Run(name+"/uncached", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for _, dir := range dirs {
+					uncachedSize(dir)
+				}
+			}
+		})
+		b.Run(name+"/update", func(b *testing.B) {
+			path := joinPath(deepest.Path(), "f0")
+			for i := 0; i < b.N; i++ {
+				if err := fs.SetFileSize(path, uint64(i)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+const inconsistentTranscript = `$ cd /
+$ ls
+dir a
+dir b
+100 x
+$ cd a
+$ ls
+200 y
+$ cd /
+$ ls
+dir a
+100 x
+150 x
+dir x
+hello
+$ cd c
+$ ls
+50 z
+`
+
+func TestCheckTranscript(t *testing.T) {
+	fs := NewFileSystem(70000000)
+	issues, err := CheckTranscript(strings.NewReader(inconsistentTranscript), &fs, TranscriptLenient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct {
+		line int
+		path string
+		err  error
+	}{
+		{13, "/x", ErrConflictingListing},
+		{14, "/x", ErrConflictingListing},
+		{15, "", ErrUnrecognizedLine},
+		{16, "/c", ErrUnknownDirectory},
+		{4, "/b", ErrUnlistedDirectory},
+	}
+	if len(issues) != len(expected) {
+		t.Fatalf("Expected %v issues but got %v: %v", len(expected), len(issues), issues)
+	}
+	for i, e := range expected {
+		if issues[i].Line != e.line || issues[i].Path != e.path || !errors.Is(issues[i], e.err) {
+			t.Errorf("Expected %v at line %v for %q but got %v", e.err, e.line, e.path, issues[i])
+		}
+	}
+
+	// The first listing of x wins and the unknown directory is created
+	if stat, err := fs.Stat("/x"); err != nil || stat.Size != 100 {
+		t.Errorf("Expected /x with 100 but got %v, %v", stat, err)
+	}
+	if fs.Root.Size() != 350 {
+		t.Errorf("Expected / to be 350 but got %v", fs.Root.Size())
+	}
+
+	fs = NewFileSystem(70000000)
+	issues, err = CheckTranscript(strings.NewReader(inconsistentTranscript), &fs, TranscriptStrict)
+	if !errors.Is(err, ErrConflictingListing) || len(issues) != 1 {
+		t.Errorf("Expected strict mode to stop at the first %v but got %v after %v", ErrConflictingListing, err, issues)
+	}
+
+	// Repeating a listing and blank lines are fine in either mode
+	fs = NewFileSystem(70000000)
+	transcript := exampleTranscript + "\n$ cd /\n" + exampleTranscript + "\n"
+	if issues, err := CheckTranscript(strings.NewReader(transcript), &fs, TranscriptStrict); err != nil || len(issues) != 0 {
+		t.Fatalf("Expected no issues but got %v, %v", issues, err)
+	}
+	if fs.Root.Size() != 48381165 {
+		t.Errorf("Expected a repeated transcript to leave / at 48381165 but got %v", fs.Root.Size())
+	}
+
+	// ReadTranscript works around inconsistencies
+	fs = NewFileSystem(70000000)
+	if err := ReadTranscript(strings.NewReader(inconsistentTranscript), &fs); err != nil {
+		t.Fatal(err)
+	}
+	if fs.Root.Size() != 350 {
+		t.Errorf("Expected / to be 350 but got %v", fs.Root.Size())
+	}
+}
+
+// renderString draws the whole file system for comparing two of them
+func renderString(t *testing.T, fsys *FileSystem) string {
+	t.Helper()
+	var sb strings.Builder
+	if err := RenderTree(&sb, fsys.Root, RenderOptions{MaxDepth: -1}); err != nil {
+		t.Fatal(err)
+	}
+	return sb.String()
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	original := loadExample(t)
+	data, err := json.Marshal(&original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), `{"diskSize":70000000,"root":{"name":"/","type":"dir","size":48381165,"children":[{"name":"a","type":"dir","size":94853,`) {
+		t.Errorf("Unexpected JSON %s", data)
+	}
+
+	var loaded FileSystem
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.DiskSize != original.DiskSize || loaded.CurrentDir != loaded.Root {
+		t.Errorf("Expected disk size %v in / but got %v in %v", original.DiskSize, loaded.DiskSize, loaded.CurrentDir.Path())
+	}
+	if renderString(t, &loaded) != renderString(t, &original) {
+		t.Errorf("Expected\n%v\nbut got\n%v", renderString(t, &original), renderString(t, &loaded))
+	}
+	checkCachedSizes(t, &loaded)
+
+	evilJSON := map[string]error{
+		`{"root":{"name":"/","type":"file"}}`:                                                                  ErrNotADirectory,
+		`{"root":{"name":"/","type":"dir","children":[{"name":"x","type":"pipe"}]}}`:                           ErrUnknownFileType,
+		`{"root":{"name":"/","type":"dir","children":[{"name":"a/b","type":"file"}]}}`:                         ErrInvalidFileName,
+		`{"root":{"name":"/","type":"dir","children":[{"name":"x","type":"file"},{"name":"x","type":"dir"}]}}`: ErrDuplicateFileName,
+	}
+	for text, expected := range evilJSON {
+		if err := json.Unmarshal([]byte(text), &loaded); !errors.Is(err, expected) {
+			t.Errorf("Expected %v for %v but got %v", expected, text, err)
+		}
+	}
+}
+
+func TestTranscriptRoundTrip(t *testing.T) {
+	original := loadExample(t)
+	var transcript strings.Builder
+	if err := GenerateTranscript(&transcript, NewTranscriptFS(&original)); err != nil {
+		t.Fatal(err)
+	}
+	loaded := NewFileSystem(70000000)
+	if err := ReadTranscript(strings.NewReader(transcript.String()), &loaded); err != nil {
+		t.Fatal(err)
+	}
+	if renderString(t, &loaded) != renderString(t, &original) {
+		t.Errorf("Expected\n%v\nbut got\n%v", renderString(t, &original), renderString(t, &loaded))
+	}
+}
+
+func TestMaterialize(t *testing.T) {
+	original := loadExample(t)
+	dir := t.TempDir()
+	if err := Materialize(dir, &original); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(filepath.Join(dir, "d", "d.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 8033020 {
+		t.Errorf("Expected d/d.log to be 8033020 bytes but got %v", info.Size())
+	}
+
+	// Back through a transcript of the real directory
+	var transcript strings.Builder
+	if err := GenerateTranscript(&transcript, os.DirFS(dir)); err != nil {
+		t.Fatal(err)
+	}
+	loaded := NewFileSystem(70000000)
+	if err := ReadTranscript(strings.NewReader(transcript.String()), &loaded); err != nil {
+		t.Fatal(err)
+	}
+	if renderString(t, &loaded) != renderString(t, &original) {
+		t.Errorf("Expected\n%v\nbut got\n%v", renderString(t, &original), renderString(t, &loaded))
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "a b"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := GenerateTranscript(io.Discard, os.DirFS(dir)); !errors.Is(err, ErrInvalidFileName) {
+		t.Errorf("Expected %v but got %v", ErrInvalidFileName, err)
+	}
+}
+
+const plannerTranscript = `$ cd /
+$ ls
+dir a
+dir b
+dir c
+$ cd a
+$ ls
+30 f
+$ cd ../b
+$ ls
+20 f
+$ cd ../c
+$ ls
+dir x
+34 f
+$ cd x
+$ ls
+26 f
+`
+
+func TestPlanDeletion(t *testing.T) {
+	fs := loadExample(t)
+	plan, err := PlanDeletion(&fs, 30000000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Needed != 8381165 || plan.Reclaimed != 24933642 || len(plan.Dirs) != 1 || plan.Dirs[0].Path != "/d" {
+		t.Errorf("Expected to delete /d for 24933642 of 8381165 but got %+v", plan)
+	}
+
+	// 110 used of 115 needs 45 freed. Half of /c and all of /b is closest
+	fs = NewFileSystem(115)
+	if err := ReadTranscript(strings.NewReader(plannerTranscript), &fs); err != nil {
+		t.Fatal(err)
+	}
+	plan, err = PlanDeletion(&fs, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []PlanEntry{{"/b", 20}, {"/c/x", 26}}
+	if plan.Needed != 45 || plan.Reclaimed != 46 || plan.FreeAfter() != 51 || fmt.Sprint(plan.Dirs) != fmt.Sprint(expected) {
+		t.Errorf("Expected to delete %v for 46 of 45 but got %+v", expected, plan)
+	}
+
+	plan, err = PlanDeletion(&fs, 5)
+	if err != nil || plan.Needed != 0 || len(plan.Dirs) != 0 {
+		t.Errorf("Expected nothing to delete but got %+v, %v", plan, err)
+	}
+	if _, err := PlanDeletion(&fs, 116); !errors.Is(err, ErrTargetUnreachable) {
+		t.Errorf("Expected %v but got %v", ErrTargetUnreachable, err)
+	}
+}
+
+func TestPlanDeletionLargeNeeded(t *testing.T) {
+	// A single directory is the only choice, so no table is needed
+	fs := NewFileSystem(5000000000)
+	if err := ReadTranscript(strings.NewReader("$ cd /\n$ ls\ndir a\n$ cd a\n$ ls\n5000000000 f\n"), &fs); err != nil {
+		t.Fatal(err)
+	}
+	plan, err := PlanDeletion(&fs, 5000000000)
+	if err != nil || plan.Reclaimed != 5000000000 || len(plan.Dirs) != 1 || plan.Dirs[0].Path != "/a" {
+		t.Errorf("Expected to delete /a but got %+v, %v", plan, err)
+	}
+
+	// Two directories that each fall short have to be combined, which would
+	// take a table far too big to allocate
+	fs = NewFileSystem(6000000000)
+	transcript := "$ cd /\n$ ls\ndir a\ndir b\n$ cd a\n$ ls\n3000000000 f\n$ cd ../b\n$ ls\n3000000000 f\n"
+	if err := ReadTranscript(strings.NewReader(transcript), &fs); err != nil {
+		t.Fatal(err)
+	}
+	if plan, err := PlanDeletion(&fs, 4000000000); !errors.Is(err, ErrPlanTooComplex) {
+		t.Errorf("Expected %v but got %+v, %v", ErrPlanTooComplex, plan, err)
+	}
+}
+
+// bruteForcePlan tries every set of non-nested directories
+func bruteForcePlan(fs *FileSystem, needed uint64) (best uint64, ok bool) {
+	var dirs []*Directory
+	fs.Walk(func(path string, f File) error {
+		if dir, isDir := f.(*Directory); isDir && !dir.IsRoot() {
+			dirs = append(dirs, dir)
+		}
+		return nil
+	})
+	isInside := func(inner, outer *Directory) bool {
+		for d := inner.Parent; ; d = d.Parent {
+			if d == outer {
+				return true
+			}
+			if d.IsRoot() {
+				return false
+			}
+		}
+	}
+	for set := 0; set < 1<<len(dirs); set++ {
+		var sum uint64
+		nested := false
+		for i := range dirs {
+			if set&(1<<i) == 0 {
+				continue
+			}
+			sum += dirs[i].Size()
+			for j := range dirs {
+				if set&(1<<j) != 0 && isInside(dirs[i], dirs[j]) {
+					nested = true
+				}
+			}
+		}
+		if !nested && sum >= needed && (!ok || sum < best) {
+			best, ok = sum, true
+		}
+	}
+	return best, ok
+}
+
+func TestPlanDeletionMatchesBruteForce(t *testing.T) {
+	rng := rand.New(rand.NewSource(7))
+	for round := 0; round < 200; round++ {
+		fs := NewFileSystem(0)
+		var dirs []*Directory
+		dirs = append(dirs, fs.Root)
+		for i := 0; i < 1+rng.Intn(11); i++ {
+			fs.CurrentDir = dirs[rng.Intn(len(dirs))]
+			name := fmt.Sprintf("d%d", i)
+			if err := fs.CreateDirectory(name); err != nil {
+				t.Fatal(err)
+			}
+			dirs = append(dirs, fs.CurrentDir.Children[name].(*Directory))
+		}
+		for i, dir := range dirs {
+			fs.CurrentDir = dir
+			if err := fs.CreateRegularFile(fmt.Sprintf("f%d", i), uint64(rng.Intn(100))); err != nil {
+				t.Fatal(err)
+			}
+		}
+		target := uint64(rng.Intn(int(fs.Root.Size()) + 1))
+
+		expected, ok := bruteForcePlan(&fs, target)
+		plan, err := PlanDeletion(&fs, target)
+		if !ok {
+			if !errors.Is(err, ErrTargetUnreachable) {
+				t.Errorf("Round %v: expected %v but got %+v, %v", round, ErrTargetUnreachable, plan, err)
+			}
+			continue
+		}
+		if err != nil || plan.Reclaimed != expected {
+			t.Errorf("Round %v: expected to reclaim %v of %v but got %+v, %v", round, expected, target, plan, err)
+			continue
+		}
+		var sum uint64
+		for _, entry := range plan.Dirs {
+			sum += entry.Size
+		}
+		if sum != plan.Reclaimed {
+			t.Errorf("Round %v: plan %+v adds up to %v", round, plan, sum)
+		}
+	}
+}
+
+func TestShell(t *testing.T) {
+	fs := loadExample(t)
+	fs.CurrentDir = fs.Root
+	shell := Shell{FS: &fs, Target: 30000000}
+	script := `cd a
+pwd
+ls
+ls e/i
+du
+cd ..
+find size > 100000 and depth > 0
+cd d
+find size > 100000
+what-if /a /a/e k
+what-if .
+rm k nope
+ls
+frob
+exit
+pwd
+`
+	var out strings.Builder
+	if err := shell.Run(strings.NewReader(script), &out); err != nil {
+		t.Fatal(err)
+	}
+	expected := `/$ /a$ /a
+/a$ dir e
+29116 f
+2557 g
+62596 h.lst
+/a$ 584 i
+/a$ 584	/a/e
+94853	/a
+/a$ /$ 24933642	/d
+/$ /d$ 24933642	/d
+/d$ Deleting /a /a/e k would free 7309149, leaving 28927984 free of 70000000
+That is 1072016 short of the 30000000 target
+/d$ Deleting . would free 24933642, leaving 46552477 free of 70000000
+That is enough for the 30000000 target
+/d$ error: nope: file does not exist
+/d$ 5626152 d.ext
+8033020 d.log
+4060174 j
+/d$ error: unknown command: frob
+/d$ `
+	if out.String() != expected {
+		t.Errorf("Expected\n%v\nbut got\n%v", expected, out.String())
+	}
+	checkCachedSizes(t, &fs)
+}
+
+func TestShellWhatIfSiblingPrefix(t *testing.T) {
+	// a.b sorts between a and a/x, but only a/x is inside a
+	transcript := `$ cd /
+$ ls
+dir a
+dir a.b
+$ cd a
+$ ls
+dir x
+$ cd x
+$ ls
+10 f
+$ cd /a.b
+$ ls
+5 f
+`
+	fs := NewFileSystem(100)
+	if err := ReadTranscript(strings.NewReader(transcript), &fs); err != nil {
+		t.Fatal(err)
+	}
+	shell := Shell{FS: &fs, Target: 100}
+	var out strings.Builder
+	if _, err := shell.Exec(&out, "what-if /a /a.b /a/x /a"); err != nil {
+		t.Fatal(err)
+	}
+	expected := "Deleting /a /a.b /a/x /a would free 15, leaving 100 free of 100\nThat is enough for the 100 target\n"
+	if out.String() != expected {
+		t.Errorf("Expected\n%v\nbut got\n%v", expected, out.String())
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"sort"
+)
+
+var (
+	ErrTargetUnreachable = errors.New("deleting every directory would not free enough space")
+	ErrPlanTooComplex    = errors.New("too many directories and bytes to plan exactly")
+)
+
+const (
+	// maxPlanWork caps the number of directories times the bytes needed
+	maxPlanWork = 1 << 33
+	// maxPlanTable caps the bytes needed, which is the length of the two
+	// int32 tables, so they take at most 1GiB between them
+	maxPlanTable = 1 << 27
+)
+
+// PlanEntry is a directory chosen for deletion
+type PlanEntry struct {
+	Path string
+	Size uint64
+}
+
+// Plan is a set of directories to delete to make room
+type Plan struct {
+	Used      uint64 // Space used before deleting anything
+	Free      uint64 // Space free before deleting anything
+	Needed    uint64 // Bytes that have to be freed to reach the target
+	Reclaimed uint64 // Bytes freed by deleting Dirs
+	Dirs      []PlanEntry
+}
+
+// FreeAfter returns the space free once the plan has been carried out
+func (p Plan) FreeAfter() uint64 {
+	return p.Free + p.Reclaimed
+}
+
+// PlanDeletion picks the set of directories that frees at least enough space
+// to leave target bytes free on a disk of fs.DiskSize, while deleting as few
+// bytes as possible. No chosen directory is inside another one, and the root
+// is never chosen.
+//
+// This is a knapsack over the directories in preorder, where taking a
+// directory jumps past everything inside it. Every position can move on to
+// the next without taking anything, so the totals reachable only grow as the
+// walk goes on. That means each total below what is needed only has to
+// remember the directory that first reached it, which is enough to rebuild a
+// plan where every directory ends before the next one starts
+func PlanDeletion(fs *FileSystem, target uint64) (Plan, error) {
+	plan := Plan{Used: fs.Root.Size()}
+	if plan.Used < fs.DiskSize {
+		plan.Free = fs.DiskSize - plan.Used
+	}
+	if plan.Free >= target {
+		return plan, nil
+	}
+	plan.Needed = target - plan.Free
+	if plan.Used < plan.Needed {
+		return plan, ErrTargetUnreachable
+	}
+
+	// Directories in preorder along with where each one's subtree ends. A
+	// directory that frees enough on its own is best taken alone, so only the
+	// smallest of those is kept as single. Anything better has to be built
+	// from directories below what is needed, and the most they can add up to
+	// is reachable, the total of the outermost ones
+	var dirs []*Directory
+	var ends []int
+	single := -1
+	var reachable uint64
+	var visit func(dir *Directory)
+	visit = func(dir *Directory) {
+		for _, name := range dir.SortedChildNames() {
+			if child, ok := dir.Children[name].(*Directory); ok {
+				i := len(dirs)
+				dirs = append(dirs, child)
+				ends = append(ends, 0)
+				if size := child.Size(); size >= plan.Needed {
+					if single < 0 || size < dirs[single].Size() {
+						single = i
+					}
+				} else if dir.IsRoot() || dir.Size() >= plan.Needed {
+					reachable += size
+				}
+				visit(child)
+				ends[i] = len(dirs)
+			}
+		}
+	}
+	visit(fs.Root)
+
+	bestSum, bestDir, bestFrom := uint64(0), single, uint64(0)
+	if single >= 0 {
+		bestSum = dirs[single].Size()
+	}
+	var firstBy []int32
+	if reachable >= plan.Needed && bestSum != plan.Needed {
+		if plan.Needed > maxPlanTable || uint64(len(dirs))*plan.Needed > maxPlanWork {
+			return plan, ErrPlanTooComplex
+		}
+
+		// firstBy[s] is the directory that first reached a total of s, and
+		// availableAt[s] is the position from which it can be built on, which
+		// is the end of that directory's subtree. The empty plan is there from
+		// the start
+		firstBy = make([]int32, plan.Needed)
+		availableAt := make([]int32, plan.Needed)
+		for s := range availableAt {
+			availableAt[s] = math.MaxInt32
+		}
+		availableAt[0] = 0
+
+		// Totals at or above what is needed are finished plans, so only the
+		// smallest one is kept
+		var maxReached uint64
+		for i, dir := range dirs {
+			size, pos, end := dir.Size(), int32(i), int32(ends[i])
+			for s := uint64(0); s <= maxReached; s++ {
+				if availableAt[s] > pos {
+					continue
+				}
+				if s+size >= plan.Needed {
+					if bestDir < 0 || s+size < bestSum {
+						bestSum, bestDir, bestFrom = s+size, i, s
+					}
+					// Larger totals from here would only overshoot further
+					break
+				}
+				if availableAt[s+size] > end {
+					availableAt[s+size] = end
+					firstBy[s+size] = pos
+					if s+size > maxReached {
+						maxReached = s + size
+					}
+				}
+			}
+			if bestDir >= 0 && bestSum == plan.Needed {
+				break
+			}
+		}
+	}
+	if bestDir < 0 {
+		return plan, ErrTargetUnreachable
+	}
+
+	plan.Reclaimed = bestSum
+	plan.Dirs = append(plan.Dirs, PlanEntry{Path: dirs[bestDir].Path(), Size: dirs[bestDir].Size()})
+	for s := bestFrom; s > 0; {
+		dir := dirs[firstBy[s]]
+		plan.Dirs = append(plan.Dirs, PlanEntry{Path: dir.Path(), Size: dir.Size()})
+		s -= dir.Size()
+	}
+	sort.Slice(plan.Dirs, func(i, j int) bool {
+		return plan.Dirs[i].Path < plan.Dirs[j].Path
+	})
+	return plan, nil
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidQuery = errors.New("invalid query")
+
+// DirInfo is an entry in the directory index
+type DirInfo struct {
+	Path  string
+	Name  string
+	Depth int // 0 for the root
+	Size  uint64
+}
+
+// BuildDirIndex lists every directory in the file system keyed by full path
+func BuildDirIndex(fs *FileSystem) map[string]DirInfo {
+	index := make(map[string]DirInfo)
+	indexDir("/", 0, fs.Root, index)
+	return index
+}
+
+// indexDir adds dir and every directory below it to the index
+func indexDir(path string, depth int, dir *Directory, index map[string]DirInfo) {
+	for name, child := range dir.Children {
+		if v, ok := child.(*Directory); ok {
+			indexDir(joinPath(path, name), depth+1, v, index)
+		}
+	}
+	name := dir.Name
+	if dir.IsRoot() {
+		name = "/"
+	}
+	index[path] = DirInfo{
+		Path:  path,
+		Name:  name,
+		Depth: depth,
+		Size:  dir.Size(),
+	}
+}
+
+// Query is a compiled filter over the directory index, such as
+//
+//	size > 100000 and depth <= 3 and name ~ "log"
+//
+// Fields are size, depth, name and path. Numbers compare with =, !=, <, <=,
+// > and >=, strings with = and != or with ~ and !~ for a regular expression
+// match. Conditions combine with and, or, not and parentheses
+type Query struct {
+	match func(DirInfo) bool
+}
+
+// Match reports whether the directory satisfies the query
+func (q *Query) Match(info DirInfo) bool {
+	return q.match(info)
+}
+
+// Run returns the directories in the index that satisfy the query, in path order
+func (q *Query) Run(index map[string]DirInfo) []DirInfo {
+	results := make([]DirInfo, 0)
+	for _, info := range index {
+		if q.Match(info) {
+			results = append(results, info)
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Path < results[j].Path
+	})
+	return results
+}
+
+// tokenRgx splits a query into quoted strings, operators, parentheses and words
+var tokenRgx = regexp.MustCompile(`"(?:[^"\\]|\\.)*"|!=|!~|<=|>=|[=<>~()]|[^\s"=<>~()!]+`)
+
+// CompileQuery parses a query string
+func CompileQuery(text string) (*Query, error) {
+	// Only whitespace may sit between tokens
+	var tokens []string
+	var prev int
+	for _, loc := range tokenRgx.FindAllStringIndex(text, -1) {
+		if strings.TrimSpace(text[prev:loc[0]]) != "" {
+			return nil, fmt.Errorf("%w: unexpected %q", ErrInvalidQuery, strings.TrimSpace(text[prev:loc[0]]))
+		}
+		tokens = append(tokens, text[loc[0]:loc[1]])
+		prev = loc[1]
+	}
+	if strings.TrimSpace(text[prev:]) != "" {
+		return nil, fmt.Errorf("%w: unexpected %q", ErrInvalidQuery, strings.TrimSpace(text[prev:]))
+	}
+	p := &queryParser{tokens: tokens}
+	match, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("%w: unexpected %q", ErrInvalidQuery, p.tokens[p.pos])
+	}
+	return &Query{match: match}, nil
+}
+
+type queryParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *queryParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *queryParser) next() (string, error) {
+	if p.pos >= len(p.tokens) {
+		return "", fmt.Errorf("%w: unexpected end of query", ErrInvalidQuery)
+	}
+	p.pos++
+	return p.tokens[p.pos-1], nil
+}
+
+// parseOr handles the lowest precedence operator
+func (p *queryParser) parseOr() (func(DirInfo) bool, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for strings.EqualFold(p.peek(), "or") {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(info DirInfo) bool { return l(info) || right(info) }
+	}
+	return left, nil
+}
+
+func (p *queryParser) parseAnd() (func(DirInfo) bool, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for strings.EqualFold(p.peek(), "and") {
+		p.pos++
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(info DirInfo) bool { return l(info) && right(info) }
+	}
+	return left, nil
+}
+
+func (p *queryParser) parseNot() (func(DirInfo) bool, error) {
+	if strings.EqualFold(p.peek(), "not") {
+		p.pos++
+		inner, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return func(info DirInfo) bool { return !inner(info) }, nil
+	}
+	if p.peek() == "(" {
+		p.pos++
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if tok, err := p.next(); err != nil || tok != ")" {
+			return nil, fmt.Errorf("%w: missing )", ErrInvalidQuery)
+		}
+		return inner, nil
+	}
+	return p.parseCondition()
+}
+
+// parseCondition handles a single "field op value" comparison
+func (p *queryParser) parseCondition() (func(DirInfo) bool, error) {
+	field, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	op, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	value, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(field) {
+	case "size":
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: size needs a number, not %q", ErrInvalidQuery, value)
+		}
+		cmp, err := compareNumbers(op)
+		if err != nil {
+			return nil, err
+		}
+		return func(info DirInfo) bool { return cmp(info.Size, n) }, nil
+	case "depth":
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: depth needs a number, not %q", ErrInvalidQuery, value)
+		}
+		cmp, err := compareNumbers(op)
+		if err != nil {
+			return nil, err
+		}
+		return func(info DirInfo) bool { return cmp(uint64(info.Depth), n) }, nil
+	case "name", "path":
+		s, err := strconv.Unquote(value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v needs a quoted string, not %v", ErrInvalidQuery, field, value)
+		}
+		get := func(info DirInfo) string { return info.Name }
+		if strings.ToLower(field) == "path" {
+			get = func(info DirInfo) string { return info.Path }
+		}
+		cmp, err := compareStrings(op, s)
+		if err != nil {
+			return nil, err
+		}
+		return func(info DirInfo) bool { return cmp(get(info)) }, nil
+	}
+	return nil, fmt.Errorf("%w: unknown field %q", ErrInvalidQuery, field)
+}
+
+func compareNumbers(op string) (func(a, b uint64) bool, error) {
+	switch op {
+	case "=":
+		return func(a, b uint64) bool { return a == b }, nil
+	case "!=":
+		return func(a, b uint64) bool { return a != b }, nil
+	case "<":
+		return func(a, b uint64) bool { return a < b }, nil
+	case "<=":
+		return func(a, b uint64) bool { return a <= b }, nil
+	case ">":
+		return func(a, b uint64) bool { return a > b }, nil
+	case ">=":
+		return func(a, b uint64) bool { return a >= b }, nil
+	}
+	return nil, fmt.Errorf("%w: %q can't compare numbers", ErrInvalidQuery, op)
+}
+
+func compareStrings(op, s string) (func(string) bool, error) {
+	switch op {
+	case "=":
+		return func(v string) bool { return v == s }, nil
+	case "!=":
+		return func(v string) bool { return v != s }, nil
+	case "~", "!~":
+		rgx, err := regexp.Compile(s)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidQuery, err)
+		}
+		if op == "!~" {
+			return func(v string) bool { return !rgx.MatchString(v) }, nil
+		}
+		return rgx.MatchString, nil
+	}
+	return nil, fmt.Errorf("%w: %q can't compare strings", ErrInvalidQuery, op)
+}
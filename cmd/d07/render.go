@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+var ErrUnknownSortOrder = errors.New("unknown sort order")
+
+const (
+	SortByName = "name"
+	SortBySize = "size"
+)
+
+// RenderOptions controls which files the tree and du renderers show and in
+// what order
+type RenderOptions struct {
+	SortBy   string // SortByName, or SortBySize for largest first
+	MaxDepth int    // Deepest level to show below the starting directory, negative for no limit
+	MinSize  uint64 // Files and directories smaller than this are hidden
+	Human    bool   // Show sizes like 1.5M instead of in bytes
+}
+
+// HumanSize formats a size in bytes with a binary unit suffix like du -h
+func HumanSize(size uint64) string {
+	const units = "BKMGTPE"
+	if size < 1024 {
+		return fmt.Sprintf("%d%c", size, units[0])
+	}
+	value := float64(size)
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	if value < 10 {
+		return fmt.Sprintf("%.1f%c", value, units[unit])
+	}
+	return fmt.Sprintf("%.0f%c", value, units[unit])
+}
+
+func (o RenderOptions) formatSize(size uint64) string {
+	if o.Human {
+		return HumanSize(size)
+	}
+	return fmt.Sprint(size)
+}
+
+// visibleChildren returns the names of the children of dir that pass the
+// size filter, in the requested order
+func (o RenderOptions) visibleChildren(dir *Directory) []string {
+	sizes := make(map[string]uint64, len(dir.Children))
+	names := make([]string, 0, len(dir.Children))
+	for _, name := range dir.SortedChildNames() {
+		size := fileSize(dir.Children[name])
+		if size < o.MinSize {
+			continue
+		}
+		sizes[name] = size
+		names = append(names, name)
+	}
+	if o.SortBy == SortBySize {
+		sort.SliceStable(names, func(i, j int) bool {
+			return sizes[names[i]] > sizes[names[j]]
+		})
+	}
+	return names
+}
+
+func (o RenderOptions) validate() error {
+	switch o.SortBy {
+	case SortByName, SortBySize, "":
+		return nil
+	}
+	return fmt.Errorf("%w: %v", ErrUnknownSortOrder, o.SortBy)
+}
+
+// RenderTree draws dir and everything below it like the tree command, with
+// the cumulative size of every directory
+func RenderTree(w io.Writer, dir *Directory, opts RenderOptions) error {
+	if err := opts.validate(); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "%v (%v)\n", dir.Path(), opts.formatSize(dir.Size())); err != nil {
+		return err
+	}
+	return renderTreeChildren(w, dir, opts, "", 1)
+}
+
+func renderTreeChildren(w io.Writer, dir *Directory, opts RenderOptions, prefix string, depth int) error {
+	if opts.MaxDepth >= 0 && depth > opts.MaxDepth {
+		return nil
+	}
+	names := opts.visibleChildren(dir)
+	for i, name := range names {
+		branch, indent := "├── ", "│   "
+		if i == len(names)-1 {
+			branch, indent = "└── ", "    "
+		}
+		child := dir.Children[name]
+		if _, err := fmt.Fprintf(w, "%v%v%v (%v)\n", prefix, branch, name, opts.formatSize(fileSize(child))); err != nil {
+			return err
+		}
+		if childDir, ok := child.(*Directory); ok {
+			if err := renderTreeChildren(w, childDir, opts, prefix+indent, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// RenderDu lists dir and every directory below it like du, with each
+// directory's contents before the directory itself
+func RenderDu(w io.Writer, dir *Directory, opts RenderOptions) error {
+	if err := opts.validate(); err != nil {
+		return err
+	}
+	return renderDu(w, dir, opts, 0)
+}
+
+func renderDu(w io.Writer, dir *Directory, opts RenderOptions, depth int) error {
+	if opts.MaxDepth < 0 || depth < opts.MaxDepth {
+		for _, name := range opts.visibleChildren(dir) {
+			if childDir, ok := dir.Children[name].(*Directory); ok {
+				if err := renderDu(w, childDir, opts, depth+1); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	size := dir.Size()
+	if size < opts.MinSize {
+		return nil
+	}
+	_, err := fmt.Fprintf(w, "%v\t%v\n", opts.formatSize(size), dir.Path())
+	return err
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+)
+
+var (
+	ErrUnknownCommand = errors.New("unknown command")
+	ErrUsage          = errors.New("usage")
+)
+
+const shellHelp = `cd [PATH]          change directory, to / without a path
+ls [PATH]          list a directory like the transcript does
+pwd                print the current directory
+du [-h] [PATH]     print directory sizes
+find QUERY         list directories below this one matching a -query
+rm PATH...         delete files or directories
+what-if PATH...    show the free space if these were deleted
+help               show this message
+exit               leave the shell
+`
+
+// Shell is an interactive session for exploring a FileSystem
+type Shell struct {
+	FS     *FileSystem
+	Target uint64 // Free space wanted, for what-if
+}
+
+// Run reads commands from in until it runs out or exit is entered. Commands
+// that fail print their error and the session carries on
+func (s *Shell) Run(in io.Reader, out io.Writer) error {
+	scanner := bufio.NewScanner(in)
+	for {
+		if _, err := fmt.Fprintf(out, "%v$ ", s.FS.CurrentDir.Path()); err != nil {
+			return err
+		}
+		if !scanner.Scan() {
+			_, err := fmt.Fprintln(out)
+			if err != nil {
+				return err
+			}
+			return scanner.Err()
+		}
+		quit, err := s.Exec(out, scanner.Text())
+		if err != nil {
+			if _, err := fmt.Fprintf(out, "error: %v\n", err); err != nil {
+				return err
+			}
+		}
+		if quit {
+			return nil
+		}
+	}
+}
+
+// Exec runs a single command line and reports whether the shell should exit
+func (s *Shell) Exec(out io.Writer, line string) (quit bool, err error) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return false, nil
+	}
+	command, rest, _ := strings.Cut(line, " ")
+	rest = strings.TrimSpace(rest)
+	args := strings.Fields(rest)
+
+	switch command {
+	case "cd":
+		if len(args) > 1 {
+			return false, fmt.Errorf("%w: cd [PATH]", ErrUsage)
+		}
+		path := "/"
+		if len(args) == 1 {
+			path = args[0]
+		}
+		return false, s.FS.ChangeDirectory(path)
+	case "ls":
+		if len(args) > 1 {
+			return false, fmt.Errorf("%w: ls [PATH]", ErrUsage)
+		}
+		path := "."
+		if len(args) == 1 {
+			path = args[0]
+		}
+		return false, s.list(out, path)
+	case "pwd":
+		_, err := fmt.Fprintln(out, s.FS.CurrentDir.Path())
+		return false, err
+	case "du":
+		opts := RenderOptions{MaxDepth: -1}
+		if len(args) > 0 && args[0] == "-h" {
+			opts.Human = true
+			args = args[1:]
+		}
+		if len(args) > 1 {
+			return false, fmt.Errorf("%w: du [-h] [PATH]", ErrUsage)
+		}
+		path := "."
+		if len(args) == 1 {
+			path = args[0]
+		}
+		return false, s.du(out, path, opts)
+	case "find":
+		return false, s.find(out, rest)
+	case "rm":
+		if len(args) == 0 {
+			return false, fmt.Errorf("%w: rm PATH...", ErrUsage)
+		}
+		for _, path := range args {
+			if err := s.FS.Remove(path); err != nil {
+				return false, err
+			}
+		}
+		return false, nil
+	case "what-if":
+		if len(args) == 0 {
+			return false, fmt.Errorf("%w: what-if PATH...", ErrUsage)
+		}
+		return false, s.whatIf(out, args)
+	case "help":
+		_, err := io.WriteString(out, shellHelp)
+		return false, err
+	case "exit", "quit":
+		return true, nil
+	}
+	return false, fmt.Errorf("%w: %v", ErrUnknownCommand, command)
+}
+
+// list prints a directory's children, or a single file, in transcript form
+func (s *Shell) list(out io.Writer, path string) error {
+	f, err := s.FS.Lookup(path)
+	if err != nil {
+		return err
+	}
+	dir, ok := f.(*Directory)
+	if !ok {
+		stat, err := s.FS.Stat(path)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(out, "%d %v\n", stat.Size, stat.Name)
+		return err
+	}
+	for _, name := range dir.SortedChildNames() {
+		switch v := dir.Children[name].(type) {
+		case *Directory:
+			_, err = fmt.Fprintf(out, "dir %v\n", name)
+		case *RegularFile:
+			_, err = fmt.Fprintf(out, "%d %v\n", v.Size, name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Shell) du(out io.Writer, path string, opts RenderOptions) error {
+	f, err := s.FS.Lookup(path)
+	if err != nil {
+		return err
+	}
+	if dir, ok := f.(*Directory); ok {
+		return RenderDu(out, dir, opts)
+	}
+	stat, err := s.FS.Stat(path)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(out, "%v\t%v\n", opts.formatSize(stat.Size), stat.Path)
+	return err
+}
+
+// find lists the directories at or below the current one that match query
+func (s *Shell) find(out io.Writer, query string) error {
+	q, err := CompileQuery(query)
+	if err != nil {
+		return err
+	}
+	cwd := s.FS.CurrentDir.Path()
+	for _, info := range q.Run(BuildDirIndex(s.FS)) {
+		if cwd != "/" && info.Path != cwd && !strings.HasPrefix(info.Path, cwd+"/") {
+			continue
+		}
+		if _, err := fmt.Fprintf(out, "%v\t%v\n", info.Size, info.Path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// whatIf reports the free space there would be after deleting paths,
+// without deleting anything. Paths inside others are only counted once
+func (s *Shell) whatIf(out io.Writer, paths []string) error {
+	stats := make([]FileStat, 0, len(paths))
+	for _, path := range paths {
+		stat, err := s.FS.Stat(path)
+		if err != nil {
+			return err
+		}
+		if stat.Path == "/" {
+			return ErrCannotRemoveRoot
+		}
+		stats = append(stats, stat)
+	}
+	// Parents have shorter paths, so they are kept before anything inside them
+	sort.Slice(stats, func(i, j int) bool {
+		return len(stats[i].Path) < len(stats[j].Path)
+	})
+
+	var freed uint64
+	kept := map[string]bool{}
+	for _, stat := range stats {
+		inside := kept[stat.Path]
+		for dir := path.Dir(stat.Path); !inside && dir != "/"; dir = path.Dir(dir) {
+			inside = kept[dir]
+		}
+		if inside {
+			continue
+		}
+		freed += stat.Size
+		kept[stat.Path] = true
+	}
+
+	used := s.FS.Root.Size()
+	var free uint64
+	if used < s.FS.DiskSize {
+		free = s.FS.DiskSize - used
+	}
+	after := free + freed
+	if _, err := fmt.Fprintf(out, "Deleting %v would free %v, leaving %v free of %v\n", strings.Join(paths, " "), freed, after, s.FS.DiskSize); err != nil {
+		return err
+	}
+	if after >= s.Target {
+		_, err := fmt.Fprintf(out, "That is enough for the %v target\n", s.Target)
+		return err
+	}
+	_, err := fmt.Fprintf(out, "That is %v short of the %v target\n", s.Target-after, s.Target)
+	return err
+}
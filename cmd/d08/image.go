@@ -0,0 +1,150 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"math"
+)
+
+// minImageSide is how many pixels the longer side of each panel is scaled
+// up to for small forests
+const minImageSide = 300
+
+var (
+	backgroundColor = color.RGBA{0x20, 0x20, 0x20, 0xff}
+	highlightColor  = color.RGBA{0x00, 0xe0, 0xff, 0xff}
+)
+
+// ForestImage lays out three panels side by side with one square of scale
+// pixels per tree: the heights in grayscale, the trees visible from outside
+// in white, and the scenic scores as a heatmap with the lines of sight from
+// the best viewpoint drawn over it
+type ForestImage struct {
+	Scale int
+	Gap   int // Pixels between panels
+}
+
+// NewForestImage picks a scale that makes small forests big enough to see
+func NewForestImage(t *TreePatch) ForestImage {
+	scale := 1
+	if side := max(t.Height, t.Width); side > 0 && side < minImageSide {
+		scale = (minImageSide + side - 1) / side
+	}
+	return ForestImage{Scale: scale, Gap: max(2, scale)}
+}
+
+// Render draws the three panels
+func (f ForestImage) Render(t *TreePatch) *image.RGBA {
+	panelWidth := t.Width * f.Scale
+	img := image.NewRGBA(image.Rect(0, 0, 3*panelWidth+2*f.Gap, t.Height*f.Scale))
+	fill(img, img.Rect, backgroundColor)
+	if t.Height == 0 || t.Width == 0 {
+		return img
+	}
+
+	s := t.Sightlines()
+	minHeight, maxHeight := t.trees[0][0].Height, t.trees[0][0].Height
+	var maxScore uint64
+	bestR, bestC := 0, 0
+	for r := 0; r < t.Height; r++ {
+		for c := 0; c < t.Width; c++ {
+			minHeight = min(minHeight, t.trees[r][c].Height)
+			maxHeight = max(maxHeight, t.trees[r][c].Height)
+			if score := s.ScenicScore(r, c); score > maxScore {
+				maxScore, bestR, bestC = score, r, c
+			}
+		}
+	}
+
+	heightsX, visibleX, scoresX := 0, panelWidth+f.Gap, 2*(panelWidth+f.Gap)
+	for r := 0; r < t.Height; r++ {
+		for c := 0; c < t.Width; c++ {
+			var gray uint8 = 0xff
+			if maxHeight > minHeight {
+				gray = uint8((t.trees[r][c].Height - minHeight) * 0xff / (maxHeight - minHeight))
+			}
+			fill(img, f.treeRect(heightsX, r, c), color.RGBA{gray, gray, gray, 0xff})
+
+			visible := color.RGBA{0, 0, 0, 0xff}
+			if s.Visible[r][c] {
+				visible = color.RGBA{0xff, 0xff, 0xff, 0xff}
+			}
+			fill(img, f.treeRect(visibleX, r, c), visible)
+
+			// Scores span many orders of magnitude so they are shown on a log scale
+			var heat float64
+			if maxScore > 0 {
+				heat = math.Log1p(float64(s.ScenicScore(r, c))) / math.Log1p(float64(maxScore))
+			}
+			fill(img, f.treeRect(scoresX, r, c), heatColor(heat))
+		}
+	}
+
+	// Lines of sight from the best viewpoint, then the viewpoint itself in
+	// every panel
+	steps := [4][2]int{Up: {-1, 0}, Down: {1, 0}, Left: {0, -1}, Right: {0, 1}}
+	for dir, step := range steps {
+		for i := 1; i <= s.Distances[dir][bestR][bestC]; i++ {
+			fill(img, f.treeRect(scoresX, bestR+step[0]*i, bestC+step[1]*i).Inset(f.Scale/3), highlightColor)
+		}
+	}
+	for _, x := range []int{heightsX, visibleX, scoresX} {
+		f.outlineTree(img, x, bestR, bestC, highlightColor)
+	}
+	return img
+}
+
+// WritePNG renders the forest and encodes it as a PNG
+func (f ForestImage) WritePNG(w io.Writer, t *TreePatch) error {
+	return png.Encode(w, f.Render(t))
+}
+
+// treeRect returns the square for the tree at r, c in the panel starting at x
+func (f ForestImage) treeRect(x, r, c int) image.Rectangle {
+	return image.Rect(x+c*f.Scale, r*f.Scale, x+(c+1)*f.Scale, (r+1)*f.Scale)
+}
+
+func fill(img *image.RGBA, rect image.Rectangle, col color.RGBA) {
+	draw.Draw(img, rect, &image.Uniform{col}, image.Point{}, draw.Src)
+}
+
+// outlineTree draws a border round the inside of a tree's square, or fills
+// the square when trees are too small to border
+func (f ForestImage) outlineTree(img *image.RGBA, x, r, c int, col color.RGBA) {
+	rect := f.treeRect(x, r, c)
+	if f.Scale < 3 {
+		fill(img, rect, col)
+		return
+	}
+	width := max(1, f.Scale/10)
+	fill(img, image.Rect(rect.Min.X, rect.Min.Y, rect.Max.X, rect.Min.Y+width), col)
+	fill(img, image.Rect(rect.Min.X, rect.Max.Y-width, rect.Max.X, rect.Max.Y), col)
+	fill(img, image.Rect(rect.Min.X, rect.Min.Y, rect.Min.X+width, rect.Max.Y), col)
+	fill(img, image.Rect(rect.Max.X-width, rect.Min.Y, rect.Max.X, rect.Max.Y), col)
+}
+
+// heatColor maps 0 to black and 1 to white through red and yellow
+func heatColor(v float64) color.RGBA {
+	v = math.Max(0, math.Min(1, v))
+	channel := func(start float64) uint8 {
+		return uint8(math.Round(0xff * math.Max(0, math.Min(1, (v-start)*3))))
+	}
+	return color.RGBA{channel(0), channel(1.0 / 3), channel(2.0 / 3), 0xff}
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,170 @@
+package main
+
+import "fmt"
+
+// Direction is one of the four ways to look from a tree
+type Direction int
+
+const (
+	Up Direction = iota
+	Down
+	Left
+	Right
+)
+
+func (d Direction) String() string {
+	switch d {
+	case Up:
+		return "up"
+	case Down:
+		return "down"
+	case Left:
+		return "left"
+	case Right:
+		return "right"
+	}
+	return fmt.Sprintf("Direction(%d)", int(d))
+}
+
+// Sightlines holds how many trees can be seen from every tree in each
+// direction, along with whether it can be seen from outside the grid
+type Sightlines struct {
+	Distances [4][][]int // Indexed by Direction
+	Visible   [][]bool
+}
+
+// ScenicScore multiplies the viewing distances of the tree at r, c
+func (s Sightlines) ScenicScore(r, c int) uint64 {
+	score := uint64(1)
+	for _, distances := range s.Distances {
+		score *= uint64(distances[r][c])
+	}
+	return score
+}
+
+type stackEntry struct {
+	pos    int
+	height int
+}
+
+// sightStack holds the trees along a line that haven't been hidden by a
+// taller one yet, nearest last
+type sightStack []stackEntry
+
+// see adds the tree at pos, counted from the end of the line being looked
+// back towards, and returns how far it can see in that direction and whether
+// it can be seen from that end. Shorter trees are popped first so the top is
+// then the nearest one at least as tall. Each tree is pushed and popped once,
+// so a whole line takes linear time
+func (s *sightStack) see(pos, height int) (dist int, seen bool) {
+	stack := *s
+	for len(stack) > 0 && stack[len(stack)-1].height < height {
+		stack = stack[:len(stack)-1]
+	}
+	if len(stack) == 0 {
+		dist, seen = pos, true
+	} else {
+		dist = pos - stack[len(stack)-1].pos
+	}
+	*s = append(stack, stackEntry{pos: pos, height: height})
+	return dist, seen
+}
+
+// sweepFunc is called by sweep for every tree in every direction
+type sweepFunc func(dir Direction, r, c, dist int, seen bool)
+
+// sweep finds the viewing distance and visibility of every tree in every
+// direction in time linear in the size of the grid. Columns are handled with
+// a stack each while going over the rows, so the grid is always read in order
+func (t *TreePatch) sweep(fn sweepFunc) {
+	var row sightStack
+	columns := make([]sightStack, t.Width)
+
+	for r := 0; r < t.Height; r++ {
+		row = row[:0]
+		for c := 0; c < t.Width; c++ {
+			dist, seen := row.see(c, t.trees[r][c].Height)
+			fn(Left, r, c, dist, seen)
+		}
+		row = row[:0]
+		for c := t.Width - 1; c >= 0; c-- {
+			dist, seen := row.see(t.Width-1-c, t.trees[r][c].Height)
+			fn(Right, r, c, dist, seen)
+		}
+		for c := 0; c < t.Width; c++ {
+			dist, seen := columns[c].see(r, t.trees[r][c].Height)
+			fn(Up, r, c, dist, seen)
+		}
+	}
+
+	for c := range columns {
+		columns[c] = columns[c][:0]
+	}
+	for r := t.Height - 1; r >= 0; r-- {
+		for c := 0; c < t.Width; c++ {
+			dist, seen := columns[c].see(t.Height-1-r, t.trees[r][c].Height)
+			fn(Down, r, c, dist, seen)
+		}
+	}
+}
+
+// Sightlines computes the viewing distances and visibility of every tree in
+// time linear in the size of the grid
+func (t *TreePatch) Sightlines() Sightlines {
+	var s Sightlines
+	for dir := range s.Distances {
+		s.Distances[dir] = makeGrid[int](t.Height, t.Width)
+	}
+	s.Visible = makeGrid[bool](t.Height, t.Width)
+	t.sweep(func(dir Direction, r, c, dist int, seen bool) {
+		s.Distances[dir][r][c] = dist
+		if seen {
+			s.Visible[r][c] = true
+		}
+	})
+	return s
+}
+
+// CountVisibleTreesLinear is CalculateExternallyVisibleTrees in linear time.
+// It doesn't touch the Visible field of the trees
+func (t *TreePatch) CountVisibleTreesLinear() int {
+	visible := makeGrid[bool](t.Height, t.Width)
+	var count int
+	t.sweep(func(dir Direction, r, c, dist int, seen bool) {
+		if seen && !visible[r][c] {
+			visible[r][c] = true
+			count++
+		}
+	})
+	return count
+}
+
+// FindHighestScenicScoreLinear is FindHighestScenicScore in linear time
+func (t *TreePatch) FindHighestScenicScoreLinear() uint64 {
+	// Down comes last for every tree, so the score is complete then
+	scores := makeGrid[uint64](t.Height, t.Width)
+	var max uint64
+	t.sweep(func(dir Direction, r, c, dist int, seen bool) {
+		switch dir {
+		case Left:
+			scores[r][c] = uint64(dist)
+		case Down:
+			if score := scores[r][c] * uint64(dist); score > max {
+				max = score
+			}
+		default:
+			scores[r][c] *= uint64(dist)
+		}
+	})
+	return max
+}
+
+// makeGrid allocates a height by width grid backed by a single slice
+func makeGrid[T any](height, width int) [][]T {
+	cells := make([]T, height*width)
+	grid := make([][]T, height)
+	for r := range grid {
+		grid[r] = cells[r*width : (r+1)*width]
+	}
+	return grid
+}
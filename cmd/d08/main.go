@@ -2,66 +2,130 @@ package main
 
 import (
 	"bufio"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
-	"regexp"
-	"strconv"
+	"strings"
 )
 
-var treeRgx = regexp.MustCompile(`[0-9]`)
+var ErrInconsistentLineLengths = errors.New("inconsistent line lengths")
+var ErrInvalidTreeHeight = errors.New("tree heights must be single digits")
+
+var (
+	partB   bool
+	pngPath string
+	topK    int
+	filter  ViewpointFilter
+)
 
 func main() {
+	// Parse flags
+	{
+		partBFlag := flag.Bool("b", false, "To switch to part b")
+		flag.StringVar(&pngPath, "png", "", "Write the heights, visible trees and scenic scores to this PNG file")
+		flag.IntVar(&topK, "top", 0, "Print a table of the K trees with the highest scenic scores")
+		flag.IntVar(&filter.MinHeight, "min-height", 0, "Leave trees shorter than this out of -top")
+		flag.BoolVar(&filter.ExcludeEdges, "no-edges", false, "Leave trees on the edge out of -top")
+		flag.Parse()
+		if partBFlag != nil {
+			partB = *partBFlag
+		}
+
+	}
+
 	// Check args
-	if len(os.Args[1:]) != 1 {
+	if len(flag.Args()) != 1 {
 		log.Fatal("Expected 1 argument containing file name!")
 	}
 
 	// Open file
-	file, err := os.Open(os.Args[1])
+	file, err := os.Open(flag.Args()[0])
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer file.Close()
 
+	treePatch, err := ReadTreePatch(file)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if pngPath != "" {
+		out, err := os.Create(pngPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := NewForestImage(&treePatch).WritePNG(out, &treePatch); err != nil {
+			out.Close()
+			log.Fatal(err)
+		}
+		if err := out.Close(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if topK > 0 {
+		if err := printViewpoints(os.Stdout, treePatch.TopViewpoints(topK, filter)); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if !partB {
+		// Part A
+		fmt.Printf("%v trees are visible from outside the grid\n", treePatch.CalculateExternallyVisibleTrees())
+	} else {
+		// Part B
+		fmt.Printf("%v is the highest scenic score possible in this grid\n", treePatch.FindHighestScenicScore())
+	}
+}
+
+// ReadTreePatch parses a grid of single digit tree heights, one row per line.
+// Blank lines are skipped
+func ReadTreePatch(r io.Reader) (TreePatch, error) {
 	var (
-		width  int
-		height int
-		trees  = make([][]Tree, 0)
+		width   int
+		height  int
+		lineNum int
+		trees   = make([][]Tree, 0)
 	)
 
-	scanner := bufio.NewScanner(file)
+	scanner := bufio.NewScanner(r)
 	for scanner.Scan() {
-		line := scanner.Text()
-		matches := treeRgx.FindAllString(line, -1)
-		treeLine := make([]Tree, len(matches))
-		for i, match := range matches {
-			if i == 0 {
-				width = len(matches)
-			} else if width != len(matches) {
-				log.Fatalln("inconsistent line lengths!")
-			}
-			height, err := strconv.Atoi(match)
-			if err != nil {
-				log.Fatal("invalid tree height")
+		line := strings.TrimSpace(scanner.Text())
+		lineNum++
+		if line == "" {
+			continue
+		}
+		treeLine := make([]Tree, len(line))
+		for i := range line {
+			if line[i] < '0' || line[i] > '9' {
+				return TreePatch{}, fmt.Errorf("line %d: %w: %q", lineNum, ErrInvalidTreeHeight, line[i])
 			}
 			treeLine[i] = Tree{
-				Height:  height,
+				Height:  int(line[i] - '0'),
 				Visible: false,
 			}
 		}
+		if height == 0 {
+			width = len(treeLine)
+		} else if width != len(treeLine) {
+			return TreePatch{}, fmt.Errorf("line %d: %w", lineNum, ErrInconsistentLineLengths)
+		}
 		trees = append(trees, treeLine)
 		height++
 	}
 	if err := scanner.Err(); err != nil {
-		log.Fatal(err)
+		return TreePatch{}, err
 	}
-	treePatch := TreePatch{
+	return TreePatch{
 		trees:  trees,
 		Height: height,
 		Width:  width,
-	}
-	fmt.Printf("%v is the highest scenic score possible in this grid\n", treePatch.FindHighestScenicScore())
+	}, nil
 }
 
 func (t *TreePatch) CalculateScenicScore(r, c int) uint64 {
@@ -114,8 +178,8 @@ func (t *TreePatch) CalculateScenicScore(r, c int) uint64 {
 
 func (t *TreePatch) FindHighestScenicScore() uint64 {
 	var max uint64
-	for r := 0; r < t.Width; r++ {
-		for c := 0; c < t.Height; c++ {
+	for r := 0; r < t.Height; r++ {
+		for c := 0; c < t.Width; c++ {
 			score := t.CalculateScenicScore(r, c)
 			if score > max {
 				max = score
@@ -125,13 +189,21 @@ func (t *TreePatch) FindHighestScenicScore() uint64 {
 	return max
 }
 
+// CalculateExternallyVisibleTrees marks every tree that can be seen from
+// outside the grid as Visible and returns how many there are
 func (t *TreePatch) CalculateExternallyVisibleTrees() int {
+	for r := range t.trees {
+		for c := range t.trees[r] {
+			t.trees[r][c].Visible = false
+		}
+	}
+
 	var visibleCount int
 	// Iterate through all rows
-	for r := 0; r < t.Width; r++ {
+	for r := 0; r < t.Height; r++ {
 		// Forward through row
 		localMax := -1
-		for c := 0; c < t.Height; c++ {
+		for c := 0; c < t.Width; c++ {
 			if t.trees[r][c].Height > localMax {
 				if !t.trees[r][c].Visible {
 					visibleCount++
@@ -154,10 +226,10 @@ func (t *TreePatch) CalculateExternallyVisibleTrees() int {
 	}
 
 	// Iterate through all columns
-	for c := 0; c < t.Height; c++ {
+	for c := 0; c < t.Width; c++ {
 		// Top to bottom
 		localMax := -1
-		for r := 0; r < t.Width; r++ {
+		for r := 0; r < t.Height; r++ {
 			if t.trees[r][c].Height > localMax {
 				if !t.trees[r][c].Visible {
 					visibleCount++
@@ -168,7 +240,7 @@ func (t *TreePatch) CalculateExternallyVisibleTrees() int {
 		}
 		// Bottom to top
 		localMax = -1
-		for r := t.Width - 1; r >= 0; r-- {
+		for r := t.Height - 1; r >= 0; r-- {
 			if t.trees[r][c].Height > localMax {
 				if !t.trees[r][c].Visible {
 					visibleCount++
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image/color"
+	"image/png"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"testing"
+)
+
+const exampleForest = `30373
+25512
+65332
+33549
+35390
+`
+
+// Seven rows of four, so swapping the dimensions reads past the end of a row
+const tallForest = `5955
+9119
+5395
+9219
+5195
+9919
+5555
+`
+
+// Three rows of eight, so swapping the dimensions misses most of each row
+const wideForest = `31415926
+27182818
+14142135
+`
+
+// loadForest parses a forest or fails the test
+func loadForest(t testing.TB, text string) TreePatch {
+	patch, err := ReadTreePatch(strings.NewReader(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return patch
+}
+
+func TestForests(t *testing.T) {
+	cases := []struct {
+		name          string
+		forest        string
+		height, width int
+		visible       int
+		scenic        uint64
+	}{
+		{"example", exampleForest, 5, 5, 21, 8},
+		{"tall", tallForest, 7, 4, 21, 10},
+		{"wide", wideForest, 3, 8, 21, 6},
+	}
+	for _, c := range cases {
+		patch := loadForest(t, c.forest)
+		if patch.Height != c.height || patch.Width != c.width {
+			t.Errorf("Expected %v to be %vx%v but got %vx%v", c.name, c.height, c.width, patch.Height, patch.Width)
+		}
+		if visible := patch.CalculateExternallyVisibleTrees(); visible != c.visible {
+			t.Errorf("Expected %v visible trees in %v but got %v", c.visible, c.name, visible)
+		}
+		if visible := patch.CalculateExternallyVisibleTrees(); visible != c.visible {
+			t.Errorf("Expected %v visible trees in %v the second time but got %v", c.visible, c.name, visible)
+		}
+		if scenic := patch.FindHighestScenicScore(); scenic != c.scenic {
+			t.Errorf("Expected a highest scenic score of %v in %v but got %v", c.scenic, c.name, scenic)
+		}
+	}
+}
+
+func TestReadTreePatch(t *testing.T) {
+	if _, err := ReadTreePatch(strings.NewReader("123\n45\n")); !errors.Is(err, ErrInconsistentLineLengths) {
+		t.Errorf("Expected %v but got %v", ErrInconsistentLineLengths, err)
+	}
+	if _, err := ReadTreePatch(strings.NewReader("123\n4x6\n789\n")); !errors.Is(err, ErrInvalidTreeHeight) {
+		t.Errorf("Expected %v but got %v", ErrInvalidTreeHeight, err)
+	}
+	if _, err := ReadTreePatch(strings.NewReader("123\n4 56\n")); !errors.Is(err, ErrInvalidTreeHeight) {
+		t.Errorf("Expected %v but got %v", ErrInvalidTreeHeight, err)
+	}
+
+	// Blank lines, like the one that usually ends a puzzle input, are skipped
+	patch := loadForest(t, exampleForest+"\n\n")
+	if patch.Height != 5 || patch.Width != 5 || patch.CalculateExternallyVisibleTrees() != 21 {
+		t.Errorf("Expected the 5x5 example with 21 visible trees but got %+v", patch)
+	}
+
+	patch = loadForest(t, "")
+	if patch.Height != 0 || patch.CalculateExternallyVisibleTrees() != 0 || patch.FindHighestScenicScore() != 0 {
+		t.Errorf("Expected nothing from an empty forest but got %+v", patch)
+	}
+}
+
+// generateForest builds a rows by cols forest of random heights below maxHeight
+func generateForest(rows, cols int, seed int64, maxHeight int) TreePatch {
+	rng := rand.New(rand.NewSource(seed))
+	trees := make([][]Tree, rows)
+	for r := range trees {
+		trees[r] = make([]Tree, cols)
+		for c := range trees[r] {
+			trees[r][c].Height = rng.Intn(maxHeight)
+		}
+	}
+	return TreePatch{trees: trees, Height: rows, Width: cols}
+}
+
+// slopeForest builds a forest that rises towards the bottom right, so every
+// tree sees all the way to the top and left edges. That is the worst case
+// for the reference methods, which walk to the edge from every tree
+func slopeForest(rows, cols int) TreePatch {
+	trees := make([][]Tree, rows)
+	for r := range trees {
+		trees[r] = make([]Tree, cols)
+		for c := range trees[r] {
+			trees[r][c].Height = r + c
+		}
+	}
+	return TreePatch{trees: trees, Height: rows, Width: cols}
+}
+
+func TestSightlinesMatchReference(t *testing.T) {
+	patches := []TreePatch{
+		loadForest(t, exampleForest),
+		loadForest(t, tallForest),
+		loadForest(t, wideForest),
+		generateForest(1, 1, 1, 10),
+		generateForest(1, 30, 2, 10),
+		generateForest(30, 1, 3, 10),
+		slopeForest(9, 13),
+	}
+	for seed := int64(0); seed < 60; seed++ {
+		maxHeight := []int{2, 10, 1000}[seed%3]
+		patches = append(patches, generateForest(1+int(seed)%17, 1+int(seed*7)%23, seed, maxHeight))
+	}
+
+	for i, patch := range patches {
+		s := patch.Sightlines()
+		visible := patch.CalculateExternallyVisibleTrees()
+		for r := 0; r < patch.Height; r++ {
+			for c := 0; c < patch.Width; c++ {
+				if s.Visible[r][c] != patch.trees[r][c].Visible {
+					t.Errorf("Forest %v: expected visibility %v at %v,%v but got %v", i, patch.trees[r][c].Visible, r, c, s.Visible[r][c])
+				}
+				if expected := patch.CalculateScenicScore(r, c); s.ScenicScore(r, c) != expected {
+					t.Errorf("Forest %v: expected score %v at %v,%v but got %v", i, expected, r, c, s.ScenicScore(r, c))
+				}
+			}
+		}
+		if count := patch.CountVisibleTreesLinear(); count != visible {
+			t.Errorf("Forest %v: expected %v visible trees but got %v", i, visible, count)
+		}
+		if expected, got := patch.FindHighestScenicScore(), patch.FindHighestScenicScoreLinear(); got != expected {
+			t.Errorf("Forest %v: expected a highest score of %v but got %v", i, expected, got)
+		}
+	}
+}
+
+// With single digit heights no walk can pass more than ten trees of each
+// height, so the reference methods are close to linear on uniform forests and
+// only fall behind on slopes where every tree sees to the edge
+var benchmarkForests = []struct {
+	name   string
+	forest func() TreePatch
+}{
+	{"uniform", func() TreePatch { return generateForest(2000, 2000, 1, 10) }},
+	{"slope", func() TreePatch { return slopeForest(2000, 2000) }},
+}
+
+func BenchmarkHighestScenicScore(b *testing.B) {
+	for _, forest := range benchmarkForests {
+		patch := forest.forest()
+		b.Run(forest.name+"/reference", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				patch.FindHighestScenicScore()
+			}
+		})
+		b.Run(forest.name+"/linear", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				patch.FindHighestScenicScoreLinear()
+			}
+		})
+	}
+}
+
+func BenchmarkVisibleTrees(b *testing.B) {
+	for _, forest := range benchmarkForests {
+		patch := forest.forest()
+		b.Run(forest.name+"/reference", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				patch.CalculateExternallyVisibleTrees()
+			}
+		})
+		b.Run(forest.name+"/linear", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				patch.CountVisibleTreesLinear()
+			}
+		})
+	}
+}
+
+func TestForestImage(t *testing.T) {
+	patch := loadForest(t, exampleForest)
+	f := NewForestImage(&patch)
+	if f.Scale != 60 || f.Gap != 60 {
+		t.Errorf("Expected a scale and gap of 60 for a 5x5 forest but got %+v", f)
+	}
+
+	var buf bytes.Buffer
+	if err := f.WritePNG(&buf, &patch); err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := img.Bounds().Size(); size.X != 3*300+2*60 || size.Y != 300 {
+		t.Errorf("Expected a 1020x300 image but got %v", size)
+	}
+
+	// centre returns the colour in the middle of a tree in a panel
+	centre := func(panel, r, c int) color.RGBA {
+		x := panel*(5*f.Scale+f.Gap) + c*f.Scale + f.Scale/2
+		return color.RGBAModel.Convert(img.At(x, r*f.Scale+f.Scale/2)).(color.RGBA)
+	}
+	white, black := color.RGBA{0xff, 0xff, 0xff, 0xff}, color.RGBA{0, 0, 0, 0xff}
+	cases := []struct {
+		panel, r, c int
+		expected    color.RGBA
+	}{
+		{0, 0, 3, color.RGBA{0xc6, 0xc6, 0xc6, 0xff}}, // A 7, where 9 is the tallest
+		{0, 3, 4, white},        // The 9
+		{0, 4, 4, black},        // A 0
+		{1, 0, 0, white},        // Edges are visible
+		{1, 2, 2, black},        // The hidden 3 in the middle
+		{2, 3, 2, white},        // The best viewpoint
+		{2, 4, 4, heatColor(0)}, // Edges score 0
+		{2, 1, 1, heatColor(math.Log1p(1) / math.Log1p(8))},
+	}
+	for _, c := range cases {
+		if got := centre(c.panel, c.r, c.c); got != c.expected {
+			t.Errorf("Expected %v in panel %v at %v,%v but got %v", c.expected, c.panel, c.r, c.c, got)
+		}
+	}
+
+	// The outline runs round the inside of the best tree's square in every panel
+	for panel := 0; panel < 3; panel++ {
+		x := panel*(5*f.Scale+f.Gap) + 2*f.Scale
+		if got := color.RGBAModel.Convert(img.At(x, 3*f.Scale+f.Scale/2)); got != highlightColor {
+			t.Errorf("Expected the best viewpoint outlined in panel %v but got %v", panel, got)
+		}
+	}
+	// Its lines of sight run up two trees to the 5 at 1,2
+	x := 2*(5*f.Scale+f.Gap) + 2*f.Scale + f.Scale/2
+	if got := color.RGBAModel.Convert(img.At(x, 1*f.Scale+f.Scale/2)); got != highlightColor {
+		t.Errorf("Expected a line of sight through 1,2 but got %v", got)
+	}
+	if got := color.RGBAModel.Convert(img.At(x, f.Scale/2)); got == highlightColor {
+		t.Errorf("Expected the line of sight to stop at 1,2")
+	}
+}
+
+func TestTopViewpoints(t *testing.T) {
+	patch := loadForest(t, exampleForest)
+	top := patch.TopViewpoints(3, ViewpointFilter{ExcludeEdges: true})
+	expected := []Viewpoint{
+		{Row: 3, Col: 2, Height: 5, Score: 8, Distances: [4]int{Up: 2, Down: 1, Left: 2, Right: 2}},
+		{Row: 2, Col: 1, Height: 5, Score: 6, Distances: [4]int{Up: 1, Down: 2, Left: 1, Right: 3}},
+		{Row: 1, Col: 2, Height: 5, Score: 4, Distances: [4]int{Up: 1, Down: 2, Left: 1, Right: 2}},
+	}
+	if fmt.Sprint(top) != fmt.Sprint(expected) {
+		t.Errorf("Expected %v but got %v", expected, top)
+	}
+	if top := patch.TopViewpoints(0, ViewpointFilter{}); len(top) != 0 {
+		t.Errorf("Expected no viewpoints for k of 0 but got %v", top)
+	}
+	// The four trees of 6 or more are all on the edge, so ties go top left first
+	if top := patch.TopViewpoints(100, ViewpointFilter{MinHeight: 6}); len(top) != 4 || top[0].Row != 0 || top[0].Col != 3 {
+		t.Errorf("Expected the four trees of 6 or more led by 0,3 but got %v", top)
+	}
+	// k is clamped to the size of the forest rather than allocated up front
+	if all := patch.TopViewpoints(2000000000, ViewpointFilter{}); len(all) != 25 || all[0].Score != 8 {
+		t.Errorf("Expected all 25 trees led by a score of 8 but got %v", all)
+	}
+
+	var table strings.Builder
+	if err := printViewpoints(&table, top[:1]); err != nil {
+		t.Fatal(err)
+	}
+	expectedTable := `  rank  row  col  height  score  up  down  left  right
+     1    3    2       5      8   2     1     2      2
+`
+	if table.String() != expectedTable {
+		t.Errorf("Expected\n%v\nbut got\n%v", expectedTable, table.String())
+	}
+}
+
+func TestTopViewpointsMatchReference(t *testing.T) {
+	for seed := int64(0); seed < 20; seed++ {
+		patch := generateForest(3+int(seed)%11, 3+int(seed*5)%13, seed, 10)
+		filter := ViewpointFilter{MinHeight: int(seed % 4), ExcludeEdges: seed%2 == 0}
+
+		// Every tree that passes the filter, in the same order
+		var all []Viewpoint
+		for r := 0; r < patch.Height; r++ {
+			for c := 0; c < patch.Width; c++ {
+				edge := r == 0 || c == 0 || r == patch.Height-1 || c == patch.Width-1
+				if patch.trees[r][c].Height < filter.MinHeight || (filter.ExcludeEdges && edge) {
+					continue
+				}
+				all = append(all, Viewpoint{Row: r, Col: c, Score: patch.CalculateScenicScore(r, c)})
+			}
+		}
+		sort.Slice(all, func(i, j int) bool {
+			return all[i].better(all[j])
+		})
+
+		k := 1 + int(seed)%7
+		top := patch.TopViewpoints(k, filter)
+		if len(top) != min(k, len(all)) {
+			t.Errorf("Seed %v: expected %v viewpoints but got %v", seed, min(k, len(all)), len(top))
+			continue
+		}
+		for i, v := range top {
+			if v.Row != all[i].Row || v.Col != all[i].Col || v.Score != all[i].Score {
+				t.Errorf("Seed %v: expected %+v at %v but got %+v", seed, all[i], i, v)
+			}
+		}
+	}
+}
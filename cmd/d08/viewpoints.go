@@ -0,0 +1,108 @@
+package main
+
+import (
+	"container/heap"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+)
+
+// Viewpoint is a tree along with how much can be seen from it
+type Viewpoint struct {
+	Row, Col  int
+	Height    int
+	Score     uint64
+	Distances [4]int // Indexed by Direction
+}
+
+// ViewpointFilter leaves trees out of TopViewpoints
+type ViewpointFilter struct {
+	MinHeight    int  // Skip trees shorter than this
+	ExcludeEdges bool // Skip trees on the edge of the grid, which always score 0
+}
+
+// better orders viewpoints by score, highest first, and then by position
+func (v Viewpoint) better(o Viewpoint) bool {
+	if v.Score != o.Score {
+		return v.Score > o.Score
+	}
+	if v.Row != o.Row {
+		return v.Row < o.Row
+	}
+	return v.Col < o.Col
+}
+
+// viewpointHeap keeps the worst of the best viewpoints so far on top
+type viewpointHeap []Viewpoint
+
+func (h viewpointHeap) Len() int           { return len(h) }
+func (h viewpointHeap) Less(i, j int) bool { return h[j].better(h[i]) }
+func (h viewpointHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *viewpointHeap) Push(x any)        { *h = append(*h, x.(Viewpoint)) }
+func (h *viewpointHeap) Pop() any {
+	old := *h
+	v := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return v
+}
+
+// TopViewpoints returns the k trees with the highest scenic scores that pass
+// filter, best first. Ties go to the tree nearest the top left. A k larger
+// than the forest returns every tree that passes
+func (t *TreePatch) TopViewpoints(k int, filter ViewpointFilter) []Viewpoint {
+	k = min(k, t.Height*t.Width)
+	if k <= 0 {
+		return nil
+	}
+	s := t.Sightlines()
+	h := make(viewpointHeap, 0, k)
+	for r := 0; r < t.Height; r++ {
+		for c := 0; c < t.Width; c++ {
+			if t.trees[r][c].Height < filter.MinHeight {
+				continue
+			}
+			if filter.ExcludeEdges && (r == 0 || c == 0 || r == t.Height-1 || c == t.Width-1) {
+				continue
+			}
+			v := Viewpoint{
+				Row:    r,
+				Col:    c,
+				Height: t.trees[r][c].Height,
+				Score:  s.ScenicScore(r, c),
+			}
+			if len(h) == k && !v.better(h[0]) {
+				continue
+			}
+			for dir := range v.Distances {
+				v.Distances[dir] = s.Distances[dir][r][c]
+			}
+			if len(h) == k {
+				h[0] = v
+				heap.Fix(&h, 0)
+			} else {
+				heap.Push(&h, v)
+			}
+		}
+	}
+	sort.Slice(h, func(i, j int) bool {
+		return h[i].better(h[j])
+	})
+	return h
+}
+
+// printViewpoints writes viewpoints as a table
+func printViewpoints(w io.Writer, viewpoints []Viewpoint) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	if _, err := fmt.Fprintln(tw, "rank\trow\tcol\theight\tscore\tup\tdown\tleft\tright\t"); err != nil {
+		return err
+	}
+	for i, v := range viewpoints {
+		_, err := fmt.Fprintf(tw, "%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t\n", i+1, v.Row, v.Col, v.Height, v.Score,
+			v.Distances[Up], v.Distances[Down], v.Distances[Left], v.Distances[Right])
+		if err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}